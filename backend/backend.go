@@ -1,9 +1,13 @@
 package backend
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"net/url"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,6 +19,8 @@ type File interface {
 	Versions() []time.Time
 	IsDir() bool
 	Data(time.Time) (io.ReadCloser, error)
+	// Size returns the number of bytes a version takes up in the backend.
+	Size(time.Time) int64
 }
 
 type Backend interface {
@@ -24,6 +30,40 @@ type Backend interface {
 	Read(path string) (File, error)
 }
 
+// A CompressedWriter can store a version whose data is already gzip
+// compressed without compressing it again. It returns the number of bytes it
+// stored, which is 0 if the version was already stored.
+type CompressedWriter interface {
+	WriteCompressed(path string, date time.Time, gz io.Reader) (int64, error)
+}
+
+// WriteCompressed writes the gzip compressed data gz as the version of path
+// at date and returns the number of bytes given to the backend. Backends that
+// aren't a CompressedWriter are given the decompressed data.
+func WriteCompressed(b Backend, path string, date time.Time, gz io.Reader) (int64, error) {
+	if w, ok := b.(CompressedWriter); ok {
+		return w.WriteCompressed(path, date, gz)
+	}
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		return 0, err
+	}
+	data := &countingReader{r: zr}
+	err = b.Write(path, date, data)
+	return data.n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
 type Closer interface {
 	Close() error
 }
@@ -46,3 +86,73 @@ var backends = map[string]func(u *url.URL) (Backend, error){}
 func Register(scheme string, creator func(u *url.URL) (Backend, error)) {
 	backends[strings.ToLower(scheme)] = creator
 }
+
+// VersionAt returns the newest version of f at or before t. If t is zero the
+// newest version is returned.
+func VersionAt(f File, t time.Time) (time.Time, bool) {
+	var found time.Time
+	for _, v := range f.Versions() {
+		if !t.IsZero() && v.After(t) {
+			continue
+		}
+		if v.After(found) {
+			found = v
+		}
+	}
+	return found, !found.IsZero()
+}
+
+// Walk calls fn with the full path of every file below root. Directories are
+// walked in lexical order and are not passed to fn.
+func Walk(b Backend, root string, fn func(p string, f File) error) error {
+	files, err := b.List(root)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	for _, f := range files {
+		p := path.Join(root, f.Name())
+		if f.IsDir() {
+			err = Walk(b, p, fn)
+		} else {
+			err = fn(p, f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitName splits the name of a stored version into the name of the file
+// and the time of the version. ok is false if name isn't a version.
+func splitName(name string) (string, time.Time, bool) {
+	if !strings.HasSuffix(name, ".gz") {
+		return "", time.Time{}, false
+	}
+	i := strings.LastIndex(name, "-")
+	if i == -1 {
+		return "", time.Time{}, false
+	}
+
+	unix, err := strconv.ParseInt(name[i+1:len(name)-3], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return name[:i], time.Unix(unix, 0), true
+}
+
+func sortVersions(versions []time.Time) {
+	slices.SortFunc(versions, func(a, b time.Time) int {
+		return a.Compare(b)
+	})
+}
+
+// VersionKey returns the name the version of p modified at t is stored under
+// relative to the root of a backend.
+func VersionKey(p string, t time.Time) string {
+	return fmt.Sprintf("%s-%d.gz", p, t.Unix())
+}
@@ -15,13 +15,14 @@ import (
 
 type FileFile struct {
 	backend  *FileBackend
-	name     string
+	path     string
 	versions []time.Time
+	sizes    map[int64]int64
 	isDir    bool
 }
 
 func (f *FileFile) Name() string {
-	return f.name
+	return path.Base(f.path)
 }
 
 func (f *FileFile) Versions() []time.Time {
@@ -32,8 +33,12 @@ func (f *FileFile) IsDir() bool {
 	return f.isDir
 }
 
+func (f *FileFile) Size(t time.Time) int64 {
+	return f.sizes[t.Unix()]
+}
+
 func (f *FileFile) Data(t time.Time) (io.ReadCloser, error) {
-	p := f.backend.path(f.name, t)
+	p := f.backend.path(f.path, t)
 	file, err := os.Open(p)
 	if err != nil {
 		return nil, err
@@ -47,19 +52,65 @@ func (f *FileFile) Data(t time.Time) (io.ReadCloser, error) {
 }
 
 type FileBackend struct {
-	root string
+	root    string
+	mode    os.FileMode
+	dirMode os.FileMode
+	// fsync flushes every version to disk before Write returns.
+	fsync bool
+	// appendOnly never replaces or deletes a stored version and makes each
+	// one read only once it is written.
+	appendOnly bool
 }
 
 func init() {
 	Register("file", func(u *url.URL) (Backend, error) {
-		return NewFile(u.Host + u.Path), nil
+		b := NewFile(u.Host + u.Path).(*FileBackend)
+
+		query := u.Query()
+		var err error
+		if mode := query.Get("mode"); mode != "" {
+			b.mode, err = parseMode(mode)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mode: %w", err)
+			}
+		}
+		if mode := query.Get("dir-mode"); mode != "" {
+			b.dirMode, err = parseMode(mode)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dir-mode: %w", err)
+			}
+		}
+		if fsync := query.Get("fsync"); fsync != "" {
+			b.fsync, err = strconv.ParseBool(fsync)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fsync: %w", err)
+			}
+		}
+		if appendOnly := query.Get("append-only"); appendOnly != "" {
+			b.appendOnly, err = strconv.ParseBool(appendOnly)
+			if err != nil {
+				return nil, fmt.Errorf("invalid append-only: %w", err)
+			}
+		}
+		return b, nil
 	})
 }
 
 func NewFile(root string) Backend {
 	return &FileBackend{
-		root: root,
+		root:    root,
+		mode:    0600,
+		dirMode: 0700,
+		fsync:   true,
+	}
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
 	}
+	return os.FileMode(mode).Perm(), nil
 }
 
 func (b *FileBackend) URI() string {
@@ -67,30 +118,109 @@ func (b *FileBackend) URI() string {
 }
 
 func (b *FileBackend) path(p string, t time.Time) string {
-	return path.Join(b.root, fmt.Sprintf("%s-%d.gz", p, t.Unix()))
+	return path.Join(b.root, VersionKey(p, t))
 }
 
 func (b *FileBackend) Write(p string, t time.Time, data io.Reader) error {
+	return b.writeVersion(p, t, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		_, err := io.Copy(zw, data)
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+func (b *FileBackend) WriteCompressed(p string, t time.Time, gz io.Reader) (int64, error) {
+	n := int64(0)
+	err := b.writeVersion(p, t, func(w io.Writer) error {
+		var err error
+		n, err = io.Copy(w, gz)
+		return err
+	})
+	return n, err
+}
+
+func (b *FileBackend) writeVersion(p string, t time.Time, write func(w io.Writer) error) error {
 	newFile := b.path(p, t)
-	err := os.MkdirAll(path.Dir(newFile), 0777)
+	if b.appendOnly {
+		_, err := os.Stat(newFile)
+		if err == nil {
+			// the version is already stored and can't be replaced
+			return nil
+		}
+	}
+	return b.writeFile(newFile, write)
+}
+
+// writeFile writes a file atomically by writing to a temporary file in the
+// same directory and renaming it.
+func (b *FileBackend) writeFile(name string, write func(w io.Writer) error) error {
+	dir := path.Dir(name)
+	err := os.MkdirAll(dir, b.dirMode)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(newFile)
+	f, err := os.CreateTemp(dir, "."+path.Base(name)+".tmp-")
 	if err != nil {
 		return err
 	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
 	defer f.Close()
-	zw := gzip.NewWriter(f)
-	defer zw.Close()
-	_, err = io.Copy(zw, data)
+
+	err = f.Chmod(b.mode)
+	if err != nil {
+		return err
+	}
+	err = write(f)
 	if err != nil {
 		return err
 	}
+	if b.fsync {
+		err = f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if b.appendOnly && !strings.HasPrefix(name, b.metaPath("")) {
+		// link fails instead of replacing a file that already exists
+		err = os.Link(tmp, name)
+		if os.IsExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = os.Chmod(name, b.mode&^0222)
+	} else {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if b.fsync {
+		return syncDir(dir)
+	}
 	return nil
 }
 
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 func (b *FileBackend) List(p string) ([]File, error) {
 	rawFiles, err := ioutil.ReadDir(path.Join(b.root, p))
 	if err != nil {
@@ -101,30 +231,39 @@ func (b *FileBackend) List(p string) ([]File, error) {
 
 	for _, rawFile := range rawFiles {
 		if rawFile.IsDir() {
+			if isMetaDir(p, rawFile.Name()) {
+				continue
+			}
 			filesMap[rawFile.Name()] = &FileFile{
 				backend:  b,
-				name:     rawFile.Name(),
+				path:     path.Join(p, rawFile.Name()),
 				versions: []time.Time{},
 				isDir:    true,
 			}
 		} else {
-			filePath, t := splitName(rawFile.Name())
-			file, ok := filesMap[filePath]
-			if ok {
-				file.versions = append(file.versions, t)
-			} else {
-				filesMap[filePath] = &FileFile{
+			name, t, ok := splitName(rawFile.Name())
+			if !ok {
+				continue
+			}
+			file, ok := filesMap[name]
+			if !ok {
+				file = &FileFile{
 					backend:  b,
-					name:     filePath,
-					versions: []time.Time{t},
+					path:     path.Join(p, name),
+					versions: []time.Time{},
+					sizes:    map[int64]int64{},
 					isDir:    false,
 				}
+				filesMap[name] = file
 			}
+			file.versions = append(file.versions, t)
+			file.sizes[t.Unix()] = rawFile.Size()
 		}
 	}
 
 	files := []File{}
 	for _, file := range filesMap {
+		sortVersions(file.versions)
 		files = append(files, file)
 	}
 	return files, err
@@ -132,6 +271,7 @@ func (b *FileBackend) List(p string) ([]File, error) {
 
 func (b *FileBackend) Read(p string) (File, error) {
 	versions := []time.Time{}
+	sizes := map[int64]int64{}
 
 	dir, name := path.Split(p)
 
@@ -142,9 +282,10 @@ func (b *FileBackend) Read(p string) (File, error) {
 
 	for _, f := range rawFiles {
 		if !f.IsDir() {
-			n, t := splitName(f.Name())
-			if n == name {
+			n, t, ok := splitName(f.Name())
+			if ok && n == name {
 				versions = append(versions, t)
+				sizes[t.Unix()] = f.Size()
 			}
 		}
 	}
@@ -153,20 +294,51 @@ func (b *FileBackend) Read(p string) (File, error) {
 		return nil, os.ErrNotExist
 	}
 
+	sortVersions(versions)
 	return &FileFile{
 		backend:  b,
-		name:     p,
+		path:     p,
 		versions: versions,
-		isDir:    true,
+		sizes:    sizes,
+		isDir:    false,
 	}, nil
 }
 
-func splitName(name string) (string, time.Time) {
-	parts := strings.Split(name, "-")
+func (b *FileBackend) metaPath(key string) string {
+	return path.Join(b.root, metaDir, key)
+}
+
+func (b *FileBackend) PutObject(key string, data []byte) error {
+	return b.writeFile(b.metaPath(key), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (b *FileBackend) GetObject(key string) ([]byte, error) {
+	return os.ReadFile(b.metaPath(key))
+}
+
+func (b *FileBackend) ListObjects(dir string) ([]string, error) {
+	entries, err := os.ReadDir(b.metaPath(dir))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
 
-	unixStr := parts[len(parts)-1]
-	unixStr = unixStr[:len(unixStr)-3]
-	unix, _ := strconv.Atoi(unixStr)
-	t := time.Unix(int64(unix), 0)
-	return strings.Join(parts[:len(parts)-1], "-"), t
+func (b *FileBackend) DeleteObject(key string) error {
+	err := os.Remove(b.metaPath(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
 }
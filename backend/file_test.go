@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBackend(t *testing.T) {
+	b := NewFile(t.TempDir())
+
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+	require.NoError(t, b.Write("/a/b-c.txt", t2, strings.NewReader("two")))
+	require.NoError(t, b.Write("/a/b-c.txt", t1, strings.NewReader("one")))
+	require.NoError(t, b.Write("/z.txt", t1, strings.NewReader("z")))
+
+	paths := []string{}
+	err := Walk(b, "/", func(p string, f File) error {
+		paths = append(paths, p)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a/b-c.txt", "/z.txt"}, paths)
+
+	f, err := b.Read("/a/b-c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "b-c.txt", f.Name())
+	assert.False(t, f.IsDir())
+	assert.Equal(t, []time.Time{t1, t2}, f.Versions())
+	assert.Greater(t, f.Size(t1), int64(0))
+
+	v, ok := VersionAt(f, time.Unix(1500, 0))
+	require.True(t, ok)
+	assert.Equal(t, t1, v)
+
+	r, err := f.Data(v)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+}
+
+func TestMetaDirHidden(t *testing.T) {
+	b := NewFile(t.TempDir())
+	require.NoError(t, b.(ObjectStore).PutObject("locks/a.lock", []byte("{}")))
+	require.NoError(t, b.Write("/a.txt", time.Unix(1000, 0), strings.NewReader("a")))
+
+	files, err := b.List("/")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "a.txt", files[0].Name())
+
+	assert.True(t, Reserved("/.backup"))
+	assert.True(t, Reserved("/.backup/locks"))
+	assert.False(t, Reserved("/.backupignore"))
+	assert.False(t, Reserved("/home/.backup"))
+}
+
+func TestWriteCompressed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte(strings.Repeat("a", 1000)))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	gz := buf.Bytes()
+
+	// plain only has the methods of Backend so the data is decompressed
+	plain := struct{ Backend }{NewFile(t.TempDir())}
+
+	testCases := []struct {
+		name    string
+		backend Backend
+		written int64
+	}{
+		{"compressed", NewFile(t.TempDir()), int64(len(gz))},
+		{"plain", plain, 1000},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := WriteCompressed(tc.backend, "/a.txt", time.Unix(1000, 0), bytes.NewReader(gz))
+			require.NoError(t, err)
+			assert.Equal(t, tc.written, n)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+)
+
+// identityKey returns the key of the object holding the identity of the files
+// b stores. Every namespace in a repository has its own identity.
+func identityKey(b Backend) string {
+	if host := NamespaceHost(b); host != "" {
+		return path.Join("ids", host)
+	}
+	return "id"
+}
+
+// Identity returns a random id that is created the first time a backend is
+// used and stays with the files it stores, so two uris can be compared to see
+// if they point at the same files. It is "" if b can't store objects.
+func Identity(b Backend) (string, error) {
+	store, ok := Objects(b)
+	if !ok {
+		return "", nil
+	}
+	key := identityKey(b)
+	id, err := store.GetObject(key)
+	if err == nil {
+		return string(id), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id = []byte(hex.EncodeToString(buf))
+	err = store.PutObject(key, id)
+	if err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"io"
+	"time"
+
+	"github.com/abibby/backup/ratelimit"
+)
+
+type limitedBackend struct {
+	Backend
+	limiters []*ratelimit.Limiter
+}
+
+// Limit throttles the data written to b by all of the limiters.
+func Limit(b Backend, limiters ...*ratelimit.Limiter) Backend {
+	if len(limiters) == 0 {
+		return b
+	}
+	return &limitedBackend{
+		Backend:  b,
+		limiters: limiters,
+	}
+}
+
+func (b *limitedBackend) Write(p string, t time.Time, data io.Reader) error {
+	return b.Backend.Write(p, t, ratelimit.NewReader(data, b.limiters...))
+}
+
+func (b *limitedBackend) WriteCompressed(p string, t time.Time, gz io.Reader) (int64, error) {
+	return WriteCompressed(b.Backend, p, t, ratelimit.NewReader(gz, b.limiters...))
+}
+
+func (b *limitedBackend) Unwrap() Backend {
+	return b.Backend
+}
+
+func (b *limitedBackend) Close() error {
+	if c, ok := b.Backend.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
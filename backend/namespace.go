@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type namespacedBackend struct {
+	Backend
+	host   string
+	prefix string
+}
+
+// namespacesDir is the directory in the metadata directory that holds the
+// files of each host. Keeping them out of the root of the repository means
+// clients without a host never see the files of the others.
+const namespacesDir = "namespaces"
+
+// Namespace stores the files written to b under the directory returned by
+// NamespaceDir so that several hosts can share one repository.
+func Namespace(b Backend, host string) (Backend, error) {
+	if host == "" || host == "." || host == ".." || strings.Contains(host, "/") {
+		return nil, fmt.Errorf("invalid host %q", host)
+	}
+	return &namespacedBackend{
+		Backend: b,
+		host:    host,
+		prefix:  NamespaceDir(host),
+	}, nil
+}
+
+// NamespaceDir returns the directory the files of host are stored under in a
+// shared repository.
+func NamespaceDir(host string) string {
+	return path.Join("/", metaDir, namespacesDir, host)
+}
+
+// NamespaceHost returns the host b stores its files for or "" if b isn't
+// namespaced.
+func NamespaceHost(b Backend) string {
+	for {
+		if n, ok := b.(*namespacedBackend); ok {
+			return n.host
+		}
+		w, ok := b.(interface{ Unwrap() Backend })
+		if !ok {
+			return ""
+		}
+		b = w.Unwrap()
+	}
+}
+
+func (b *namespacedBackend) URI() string {
+	return b.Backend.URI() + b.prefix
+}
+
+func (b *namespacedBackend) Write(p string, t time.Time, data io.Reader) error {
+	return b.Backend.Write(path.Join(b.prefix, p), t, data)
+}
+
+func (b *namespacedBackend) WriteCompressed(p string, t time.Time, gz io.Reader) (int64, error) {
+	return WriteCompressed(b.Backend, path.Join(b.prefix, p), t, gz)
+}
+
+func (b *namespacedBackend) List(p string) ([]File, error) {
+	return b.Backend.List(path.Join(b.prefix, p))
+}
+
+func (b *namespacedBackend) Read(p string) (File, error) {
+	return b.Backend.Read(path.Join(b.prefix, p))
+}
+
+func (b *namespacedBackend) Unwrap() Backend {
+	return b.Backend
+}
+
+func (b *namespacedBackend) Close() error {
+	if c, ok := b.Backend.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespace(t *testing.T) {
+	root := NewFile(t.TempDir())
+	a, err := Namespace(root, "a")
+	require.NoError(t, err)
+	b, err := Namespace(root, "b")
+	require.NoError(t, err)
+
+	t1 := time.Unix(1000, 0)
+	require.NoError(t, a.Write("/x.txt", t1, strings.NewReader("a")))
+	require.NoError(t, b.Write("/y.txt", t1, strings.NewReader("b")))
+
+	_, err = a.Read("/y.txt")
+	assert.Error(t, err)
+	f, err := a.Read("/x.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{t1}, f.Versions())
+
+	_, err = root.Read(NamespaceDir("b") + "/y.txt")
+	assert.NoError(t, err)
+	// the namespaces are hidden from clients without a host
+	files, err := root.List("/")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, ok := Objects(a)
+	assert.True(t, ok)
+
+	for _, host := range []string{"", ".", "..", "a/b"} {
+		_, err = Namespace(root, host)
+		assert.Error(t, err, host)
+	}
+}
@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"path"
+	"strings"
+)
+
+// metaDir is the directory in the root of a backend that holds objects that
+// aren't backed up files, e.g. locks and the files of each host. List hides
+// it so it isn't mistaken for backed up files.
+const metaDir = ".backup"
+
+// Reserved reports whether p is in the metadata directory, where files can't
+// be backed up.
+func Reserved(p string) bool {
+	p = path.Clean("/" + p)
+	return p == "/"+metaDir || strings.HasPrefix(p, "/"+metaDir+"/")
+}
+
+// isMetaDir reports whether the entry name in the directory p of a backend is
+// the metadata directory.
+func isMetaDir(p, name string) bool {
+	return name == metaDir && path.Clean("/"+p) == "/"
+}
+
+// An ObjectStore can store small objects outside of the backed up files. Keys
+// are slash separated paths relative to the backend's metadata directory.
+type ObjectStore interface {
+	PutObject(key string, data []byte) error
+	// GetObject returns an error matching os.ErrNotExist if key doesn't exist.
+	GetObject(key string) ([]byte, error)
+	// ListObjects returns the names of the objects in dir. It is empty if dir
+	// doesn't exist.
+	ListObjects(dir string) ([]string, error)
+	DeleteObject(key string) error
+}
+
+// Objects returns the ObjectStore of b, looking through any wrappers.
+func Objects(b Backend) (ObjectStore, bool) {
+	for {
+		if s, ok := b.(ObjectStore); ok {
+			return s, true
+		}
+		w, ok := b.(interface{ Unwrap() Backend })
+		if !ok {
+			return nil, false
+		}
+		b = w.Unwrap()
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"math"
 	"net/url"
 	"os"
 	"path"
@@ -21,13 +20,14 @@ import (
 
 type S3File struct {
 	backend  *S3Backend
-	name     string
+	path     string
 	versions []time.Time
+	sizes    map[int64]int64
 	isDir    bool
 }
 
 func (f *S3File) Name() string {
-	return f.name
+	return path.Base(f.path)
 }
 
 func (f *S3File) Versions() []time.Time {
@@ -38,8 +38,19 @@ func (f *S3File) IsDir() bool {
 	return f.isDir
 }
 
+func (f *S3File) Size(t time.Time) int64 {
+	return f.sizes[t.Unix()]
+}
+
 func (f *S3File) Data(t time.Time) (io.ReadCloser, error) {
-	return nil, nil
+	object, err := f.backend.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(f.backend.bucket),
+		Key:    aws.String(f.backend.path(f.path, t)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
 }
 
 type S3Backend struct {
@@ -90,37 +101,36 @@ func (b *S3Backend) URI() string {
 }
 
 func (b *S3Backend) path(p string, t time.Time) string {
-	return strings.TrimPrefix(path.Join(b.root, fmt.Sprintf("%s-%d.gz", p, t.Unix())), "/")
+	return strings.TrimPrefix(path.Join(b.root, VersionKey(p, t)), "/")
 }
 
 func (b *S3Backend) Write(p string, t time.Time, data io.Reader) error {
 	ctx := context.Background()
 
-	fileSize := int64(0)
-
-	if file, ok := data.(*os.File); ok {
-		stat, err := file.Stat()
-		if err == nil {
-			fileSize = stat.Size()
-		}
-	}
-
 	key := b.path(p, t)
 
 	err := b.mkdir(ctx, path.Dir(key))
 	if err != nil {
 		return err
 	}
-	if fileSize < b.multipartChunkSize {
+
+	// the first chunk is read into a buffer that only grows as needed so
+	// small files don't allocate a whole chunk
+	first := &bytes.Buffer{}
+	_, err = first.ReadFrom(io.LimitReader(data, b.multipartChunkSize))
+	if err != nil {
+		return err
+	}
+	if int64(first.Len()) < b.multipartChunkSize {
 		_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
 			Bucket: aws.String(b.bucket),
 			Key:    aws.String(key),
-			Body:   data,
+			Body:   bytes.NewReader(first.Bytes()),
 		})
 		return err
 	}
 
-	return b.uploadMultipart(ctx, key, data)
+	return b.uploadMultipart(ctx, key, first.Bytes(), data)
 }
 
 func (b *S3Backend) mkdir(ctx context.Context, key string) error {
@@ -154,7 +164,10 @@ func (b *S3Backend) mkdir(ctx context.Context, key string) error {
 	b.createdFolders.Add(key)
 	return nil
 }
-func (b *S3Backend) uploadMultipart(ctx context.Context, key string, data io.Reader) error {
+
+// uploadMultipart uploads data in chunks the size of buf. buf must already
+// contain the first chunk of the file.
+func (b *S3Backend) uploadMultipart(ctx context.Context, key string, buf []byte, data io.Reader) error {
 	upload, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(key),
@@ -163,32 +176,14 @@ func (b *S3Backend) uploadMultipart(ctx context.Context, key string, data io.Rea
 		return err
 	}
 
-	size, err := readerSize(data)
+	parts, err := b.uploadParts(ctx, key, upload.UploadId, buf, data)
 	if err != nil {
-		return errors.Wrap(err, "failed to get file size")
-	}
-	parts := []types.CompletedPart{}
-	numChunks := int64(math.Ceil(float64(size) / float64(b.multipartChunkSize)))
-	for i := int64(0); i < numChunks; i++ {
-		length := b.multipartChunkSize
-		if i == numChunks-1 {
-			length = size % b.multipartChunkSize
-		}
-		part, err := b.client.UploadPart(ctx, &s3.UploadPartInput{
-			Bucket:        aws.String(b.bucket),
-			Key:           aws.String(key),
-			PartNumber:    int32(i + 1),
-			UploadId:      upload.UploadId,
-			Body:          io.NewSectionReader(data.(io.ReaderAt), i*b.multipartChunkSize, b.multipartChunkSize),
-			ContentLength: length,
-		})
-		if err != nil {
-			return errors.Wrap(err, "failed to upload part")
-		}
-		parts = append(parts, types.CompletedPart{
-			ETag:       part.ETag,
-			PartNumber: int32(i + 1),
+		_, _ = b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(b.bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
 		})
+		return err
 	}
 
 	_, err = b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
@@ -205,68 +200,202 @@ func (b *S3Backend) uploadMultipart(ctx context.Context, key string, data io.Rea
 	return nil
 }
 
+func (b *S3Backend) uploadParts(ctx context.Context, key string, uploadID *string, buf []byte, data io.Reader) ([]types.CompletedPart, error) {
+	parts := []types.CompletedPart{}
+	n := len(buf)
+	last := false
+	for partNumber := int32(1); ; partNumber++ {
+		part, err := b.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(b.bucket),
+			Key:           aws.String(key),
+			PartNumber:    partNumber,
+			UploadId:      uploadID,
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: int64(n),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to upload part")
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: partNumber,
+		})
+
+		if last {
+			return parts, nil
+		}
+
+		n, err = io.ReadFull(data, buf)
+		if err == io.EOF {
+			return parts, nil
+		} else if err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dirKey returns the prefix of every key inside the directory p.
+func (b *S3Backend) dirKey(p string) string {
+	key := strings.TrimPrefix(path.Join(b.root, p), "/")
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+// listObjects lists the keys and sub directories directly inside prefix.
+func (b *S3Backend) listObjects(ctx context.Context, prefix string) ([]string, []types.Object, error) {
+	dirs := []string{}
+	objects := []types.Object{}
+	var token *string
+	for {
+		page, err := b.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(b.bucket),
+			Prefix:            aws.String(prefix),
+			Delimiter:         aws.String("/"),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range page.CommonPrefixes {
+			dirs = append(dirs, *p.Prefix)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated {
+			return dirs, objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
 func (b *S3Backend) List(p string) ([]File, error) {
 	ctx := context.Background()
-	objects, err := b.client.ListObjects(ctx, &s3.ListObjectsInput{
-		Bucket: aws.String(b.bucket),
-		Prefix: aws.String(path.Join(b.root, p)),
-	})
+	dirs, objects, err := b.listObjects(ctx, b.dirKey(p))
 	if err != nil {
 		return nil, err
 	}
 
 	filesMap := map[string]*S3File{}
 
-	for _, object := range objects.Contents {
-		filePath, t := splitName(strings.Replace(*object.Key, b.root, "", 1))
-		file, ok := filesMap[filePath]
-		if ok {
-			file.versions = append(file.versions, t)
-		} else {
-			filesMap[filePath] = &S3File{
+	for _, dir := range dirs {
+		name := path.Base(strings.TrimSuffix(dir, "/"))
+		if isMetaDir(p, name) {
+			continue
+		}
+		filesMap[name] = &S3File{
+			backend:  b,
+			path:     path.Join(p, name),
+			versions: []time.Time{},
+			isDir:    true,
+		}
+	}
+
+	for _, object := range objects {
+		name, t, ok := splitName(path.Base(*object.Key))
+		if !ok {
+			continue
+		}
+		file, ok := filesMap[name]
+		if !ok {
+			file = &S3File{
 				backend:  b,
-				name:     filePath,
-				versions: []time.Time{t},
+				path:     path.Join(p, name),
+				versions: []time.Time{},
+				sizes:    map[int64]int64{},
 				isDir:    false,
 			}
+			filesMap[name] = file
 		}
+		file.versions = append(file.versions, t)
+		file.sizes[t.Unix()] = object.Size
 	}
 
-	files := make([]File, 0, len(objects.Contents))
+	files := make([]File, 0, len(filesMap))
 	for _, file := range filesMap {
+		sortVersions(file.versions)
 		files = append(files, file)
 	}
-	return files, err
+	return files, nil
 }
 
 func (b *S3Backend) Read(p string) (File, error) {
-	files, err := b.List(p)
+	ctx := context.Background()
+	dir, name := path.Split(p)
+	_, objects, err := b.listObjects(ctx, b.dirKey(dir)+name+"-")
 	if err != nil {
 		return nil, err
 	}
 
-	if len(files) != 1 {
-		return nil, os.ErrNotExist
+	file := &S3File{
+		backend:  b,
+		path:     p,
+		versions: []time.Time{},
+		sizes:    map[int64]int64{},
+		isDir:    false,
+	}
+	for _, object := range objects {
+		n, t, ok := splitName(path.Base(*object.Key))
+		if ok && n == name {
+			file.versions = append(file.versions, t)
+			file.sizes[t.Unix()] = object.Size
+		}
 	}
 
-	file := files[0]
-
-	if file.Name() != p {
+	if len(file.versions) == 0 {
 		return nil, os.ErrNotExist
 	}
 
+	sortVersions(file.versions)
 	return file, nil
 }
 
-func readerSize(r io.Reader) (int64, error) {
+func (b *S3Backend) metaKey(key string) string {
+	return strings.TrimPrefix(path.Join(b.root, metaDir, key), "/")
+}
 
-	if r, ok := r.(*os.File); ok {
-		stat, err := r.Stat()
-		if err != nil {
-			return 0, errors.Wrap(err, "unable to get file stat")
-		}
-		return stat.Size(), nil
+func (b *S3Backend) PutObject(key string, data []byte) error {
+	_, err := b.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.metaKey(key)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (b *S3Backend) GetObject(key string) ([]byte, error) {
+	object, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.metaKey(key)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
 	}
+	defer object.Body.Close()
+	return io.ReadAll(object.Body)
+}
 
-	return 0, fmt.Errorf("this reader does not support length")
+func (b *S3Backend) ListObjects(dir string) ([]string, error) {
+	_, objects, err := b.listObjects(context.Background(), b.metaKey(dir)+"/")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		names = append(names, path.Base(*object.Key))
+	}
+	return names, nil
+}
+
+func (b *S3Backend) DeleteObject(key string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.metaKey(key)),
+	})
+	return err
 }
@@ -2,11 +2,13 @@ package backend
 
 import (
 	"compress/gzip"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -15,13 +17,14 @@ import (
 
 type SFTPFile struct {
 	backend  *SFTPBackend
-	name     string
+	path     string
 	versions []time.Time
+	sizes    map[int64]int64
 	isDir    bool
 }
 
 func (f *SFTPFile) Name() string {
-	return f.name
+	return path.Base(f.path)
 }
 
 func (f *SFTPFile) Versions() []time.Time {
@@ -32,8 +35,12 @@ func (f *SFTPFile) IsDir() bool {
 	return f.isDir
 }
 
+func (f *SFTPFile) Size(t time.Time) int64 {
+	return f.sizes[t.Unix()]
+}
+
 func (f *SFTPFile) Data(t time.Time) (io.ReadCloser, error) {
-	p := f.backend.path(f.name, t)
+	p := f.backend.path(f.path, t)
 	file, err := f.backend.sftpClient.Open(p)
 	if err != nil {
 		return nil, err
@@ -100,10 +107,31 @@ func (b *SFTPBackend) URI() string {
 }
 
 func (b *SFTPBackend) path(p string, t time.Time) string {
-	return path.Join(b.root, fmt.Sprintf("%s-%d.gz", p, t.Unix()))
+	return path.Join(b.root, VersionKey(p, t))
 }
 
 func (b *SFTPBackend) Write(p string, t time.Time, data io.Reader) error {
+	return b.writeVersion(p, t, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		_, err := io.Copy(zw, data)
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+func (b *SFTPBackend) WriteCompressed(p string, t time.Time, gz io.Reader) (int64, error) {
+	n := int64(0)
+	err := b.writeVersion(p, t, func(w io.Writer) error {
+		var err error
+		n, err = io.Copy(w, gz)
+		return err
+	})
+	return n, err
+}
+
+func (b *SFTPBackend) writeVersion(p string, t time.Time, write func(w io.Writer) error) error {
 	newFile := b.path(p, t)
 	err := b.sftpClient.MkdirAll(path.Dir(newFile))
 	if err != nil {
@@ -114,13 +142,15 @@ func (b *SFTPBackend) Write(p string, t time.Time, data io.Reader) error {
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	zw := gzip.NewWriter(f)
-	defer zw.Close()
-
-	_, err = io.Copy(zw, data)
+	err = write(f)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		// don't leave a partial version behind
+		b.sftpClient.Remove(newFile)
 		return err
 	}
 	return nil
@@ -136,30 +166,39 @@ func (b *SFTPBackend) List(p string) ([]File, error) {
 
 	for _, rawFile := range rawFiles {
 		if rawFile.IsDir() {
+			if isMetaDir(p, rawFile.Name()) {
+				continue
+			}
 			filesMap[rawFile.Name()] = &SFTPFile{
 				backend:  b,
-				name:     rawFile.Name(),
+				path:     path.Join(p, rawFile.Name()),
 				versions: []time.Time{},
 				isDir:    true,
 			}
 		} else {
-			filePath, t := splitName(rawFile.Name())
-			file, ok := filesMap[filePath]
-			if ok {
-				file.versions = append(file.versions, t)
-			} else {
-				filesMap[filePath] = &SFTPFile{
+			name, t, ok := splitName(rawFile.Name())
+			if !ok {
+				continue
+			}
+			file, ok := filesMap[name]
+			if !ok {
+				file = &SFTPFile{
 					backend:  b,
-					name:     filePath,
-					versions: []time.Time{t},
+					path:     path.Join(p, name),
+					versions: []time.Time{},
+					sizes:    map[int64]int64{},
 					isDir:    false,
 				}
+				filesMap[name] = file
 			}
+			file.versions = append(file.versions, t)
+			file.sizes[t.Unix()] = rawFile.Size()
 		}
 	}
 
 	files := []File{}
 	for _, file := range filesMap {
+		sortVersions(file.versions)
 		files = append(files, file)
 	}
 	return files, err
@@ -167,6 +206,7 @@ func (b *SFTPBackend) List(p string) ([]File, error) {
 
 func (b *SFTPBackend) Read(p string) (File, error) {
 	versions := []time.Time{}
+	sizes := map[int64]int64{}
 
 	dir, name := path.Split(p)
 
@@ -177,9 +217,10 @@ func (b *SFTPBackend) Read(p string) (File, error) {
 
 	for _, f := range rawFiles {
 		if !f.IsDir() {
-			n, t := splitName(f.Name())
-			if n == name {
+			n, t, ok := splitName(f.Name())
+			if ok && n == name {
 				versions = append(versions, t)
+				sizes[t.Unix()] = f.Size()
 			}
 		}
 	}
@@ -188,14 +229,81 @@ func (b *SFTPBackend) Read(p string) (File, error) {
 		return nil, os.ErrNotExist
 	}
 
+	sortVersions(versions)
 	return &SFTPFile{
 		backend:  b,
-		name:     p,
+		path:     p,
 		versions: versions,
-		isDir:    true,
+		sizes:    sizes,
+		isDir:    false,
 	}, nil
 }
 
+func (b *SFTPBackend) metaPath(key string) string {
+	return path.Join(b.root, metaDir, key)
+}
+
+func (b *SFTPBackend) PutObject(key string, data []byte) error {
+	name := b.metaPath(key)
+	err := b.sftpClient.MkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	// every writer has its own temporary file so concurrent writes of the same
+	// object don't interleave
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+".tmp-"+hex.EncodeToString(suffix))
+	f, err := b.sftpClient.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		b.sftpClient.Remove(tmp)
+		return err
+	}
+	return b.sftpClient.PosixRename(tmp, name)
+}
+
+func (b *SFTPBackend) GetObject(key string) ([]byte, error) {
+	f, err := b.sftpClient.Open(b.metaPath(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (b *SFTPBackend) ListObjects(dir string) ([]string, error) {
+	entries, err := b.sftpClient.ReadDir(b.metaPath(dir))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (b *SFTPBackend) DeleteObject(key string) error {
+	err := b.sftpClient.Remove(b.metaPath(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 func (b *SFTPBackend) Close() error {
 	sftpErr := b.sftpClient.Close()
 	sshErr := b.sshClient.Close()
@@ -1,33 +1,86 @@
 package backup
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
-	"path"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/bytesize"
 	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/queue"
 	"github.com/abibby/backup/stack"
 )
 
 type Options struct {
 	Backends []backend.Backend
-	Ignore   []string
+	// Ignore holds patterns with .gitignore semantics relative to the backup
+	// directory.
+	Ignore []string
+	// IgnoreFile is the name of the per directory ignore files, e.g.
+	// .backupignore.
+	IgnoreFile string
+	// ExcludeIfPresent skips any directory containing one of these files.
+	ExcludeIfPresent []string
+	// Include limits the backup to files matching these patterns. If it is
+	// empty every file that isn't ignored is included.
+	Include []string
+	// MaxSize and MinSize limit the size of files backed up when they are
+	// greater than 0.
+	MaxSize int64
+	MinSize int64
+	// NewerThan skips files that were last modified before it.
+	NewerThan time.Time
+	// OneFileSystem stops the scan from crossing into other mounted file
+	// systems.
+	OneFileSystem bool
+	// ChangeRetries is the number of times a file that changes while it is
+	// being read is read again. If it still changes the last copy is kept and
+	// the file is listed in the report's Changed files.
+	ChangeRetries int
+	// DryRun lists the files that would be uploaded to Output and the report's
+	// Pending files without writing to the backends or the database.
+	DryRun bool
+	Output io.Writer
+	// Progress is called every second while the backup is running and once
+	// when it finishes. If it is nil progress is logged.
+	Progress func(Progress)
+}
+
+// A Source is a directory to back up along with ignore rules that only apply
+// to it.
+type Source struct {
+	Dir    string   `mapstructure:"path"`
+	Ignore []string `mapstructure:"ignore"`
+	// Snapshot is a read only copy of Dir to read the files from. The files
+	// are still stored under Dir.
+	Snapshot string `mapstructure:"-"`
 }
 
 type File struct {
-	Path     string
+	Path string
+	// ReadPath is where the file is read from if it is in a snapshot.
+	ReadPath string
 	Modified time.Time
+	Size     int64
+	// Backends are the backends that don't have the current version of the
+	// file.
+	Backends []backend.Backend
 }
 
-func printTime(start time.Time) {
-	duration := time.Since(start)
+func printTime(duration time.Duration) {
 	if duration > time.Second {
 		duration = duration.Truncate(time.Second)
 	}
@@ -37,112 +90,152 @@ func printTime(start time.Time) {
 	slog.Info("Backup complete", "duration", duration)
 }
 
-func Backup(db *database.DB, dir string, o *Options) error {
-	err := db.InitializeBackends(o.Backends)
-	if err != nil {
-		return fmt.Errorf("failed to initialize backends in local database: %w", err)
+// CheckSources returns an error if a directory is listed twice or is inside
+// another source. Their files would be backed up twice, at the same time, to
+// the same versions. The directories must be absolute.
+func CheckSources(sources []Source) error {
+	for i, a := range sources {
+		for _, b := range sources[i+1:] {
+			if a.Dir == b.Dir {
+				return fmt.Errorf("%s is listed more than once", a.Dir)
+			}
+			outer, inner := a.Dir, b.Dir
+			if len(inner) < len(outer) {
+				outer, inner = inner, outer
+			}
+			if strings.HasPrefix(inner, strings.TrimSuffix(outer, "/")+"/") {
+				return fmt.Errorf("%s is inside %s, use ignore rules on %s instead", inner, outer, outer)
+			}
+		}
 	}
-	defer printTime(time.Now())
+	return nil
+}
 
-	var wg sync.WaitGroup
-	wg.Add(3)
+func Backup(db *database.DB, sources []Source, o *Options) (*Report, error) {
+	report := newReport(o.Backends)
+	defer func() {
+		report.Duration = time.Since(report.Start)
+		printTime(report.Duration)
+	}()
+
+	report.DryRun = o.DryRun
+	err := CheckSources(sources)
+	if err != nil {
+		return report, err
+	}
+	if !o.DryRun {
+		err := db.InitializeBackends(o.Backends)
+		if err != nil {
+			return report, fmt.Errorf("failed to initialize backends in local database: %w", err)
+		}
+	}
 
+	progress := newProgressTracker()
 	files := stack.NewSyncDone[File]()
-	fileQueue := make(chan File, 16)
-	fileComplete := make(chan struct{}, 16)
-	backupDone := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
 
 	var scanError error
+	var seen map[string]bool
 	go func() {
 		defer wg.Done()
-		scanError = scanFolder(dir, db, o, fileQueue)
+		seen, scanError = scanSources(sources, db, o, report, progress, files)
 		files.Finish(true)
+		progress.scanning.Store(false)
 	}()
 
 	var backupError error
 	go func() {
 		defer wg.Done()
-		backupError = backupFiles(db, o, files, fileComplete)
-		backupDone <- struct{}{}
+		backupError = backupFiles(db, o, files, report, progress)
 	}()
 
+	done := make(chan struct{})
+	reported := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		total := 0
-		done := 0
-		ticker := time.NewTicker(time.Second)
-		start := time.Now()
-		for {
-			select {
-			case f := <-fileQueue:
-				files.Push(f)
-				total++
-			case <-fileComplete:
-				done++
-			case <-backupDone:
-				return
-			case now := <-ticker.C:
-				runTime := now.Sub(start)
-				remaining := total - done
-				var timePerFile time.Duration
-				if done > 0 {
-					timePerFile = runTime / time.Duration(done)
-				}
-				timeRemaining := timePerFile * time.Duration(remaining)
-				endingAt := now.Add(timeRemaining)
-				slog.Info("backing up",
-					"total", total,
-					"done", done,
-					"remaining", timeRemaining.Truncate(time.Second),
-					"end", endingAt,
-				)
-			}
-		}
+		defer close(reported)
+		progress.report(o, done)
 	}()
 
 	wg.Wait()
-	close(backupDone)
-	close(fileQueue)
-	close(fileComplete)
+	close(done)
+	// the last update is sent by report so it can't overlap a tick
+	<-reported
 
-	return errors.Join(scanError, backupError)
+	if !o.DryRun && seen != nil {
+		markDeleted(db, o.Backends, sources, seen, report)
+	}
+
+	return report, errors.Join(scanError, backupError)
 }
-func scanFolder(dir string, db *database.DB, o *Options, filesChan chan File) error {
-	var err error
-	files, err := os.ReadDir(dir)
 
-	if err != nil {
-		return fmt.Errorf("failed to load directory %s: %w", dir, err)
+// scanSources scans every source and returns the files it found. The files
+// are nil if any part of a source couldn't be read.
+func scanSources(sources []Source, db *database.DB, o *Options, report *Report, progress *progressTracker, files *stack.SyncDoneStack[File]) (map[string]bool, error) {
+	var errs []error
+	seen := map[string]bool{}
+	for _, source := range sources {
+		s := newScanner(source, db, o, report, progress, files)
+		err := s.scan()
+		if err != nil {
+			slog.Error("failed to scan directory", "dir", source.Dir, "err", err)
+			errs = append(errs, err)
+		}
+		if err != nil || s.incomplete {
+			seen = nil
+		} else if seen != nil {
+			maps.Copy(seen, s.seen)
+		}
 	}
+	return seen, errors.Join(errs...)
+}
 
-	for _, f := range files {
-		p := path.Join(dir, f.Name())
-		if matches(p, o.Ignore) {
-			continue
+// markDeleted records that files in sources that were backed up before but
+// are no longer on disk have been deleted. Files that weren't seen because
+// they are now ignored or filtered out are still on disk and are kept.
+func markDeleted(db *database.DB, backends []backend.Backend, sources []Source, seen map[string]bool, report *Report) {
+	now := time.Now()
+	exists := func(p string) bool {
+		if seen[p] {
+			return true
 		}
-		if f.IsDir() {
-			err = scanFolder(p, db, o, filesChan)
-			if err != nil {
-				slog.Error("failed to backup file", "file", p, "err", err)
-			}
-		} else if f.Type()&os.ModeSymlink != 0 {
-			// ignore symlinks
-		} else {
-			info, err := f.Info()
-			if err != nil {
-				slog.Error("failed to queue file", "file", p, "err", err)
-				continue
-			}
-			filesChan <- File{
-				Path:     p,
-				Modified: info.ModTime(),
+		for _, source := range sources {
+			if strings.HasPrefix(p, source.Dir+"/") {
+				_, err := os.Lstat(p)
+				return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR)
 			}
 		}
+		return true
+	}
+	for _, b := range backends {
+		n, err := db.MarkDeleted(b, exists, now)
+		if err != nil {
+			slog.Error("failed to record deleted files", "backend", b.URI(), "err", err)
+			report.addError(err)
+			continue
+		}
+		report.Backends[b.URI()].Deleted = n
 	}
-	return nil
 }
 
-func needsUpdate(db *database.DB, b backend.Backend, f File) (bool, error) {
+// Files calls fn with every file in sources that a backup with the options o
+// would consider, whether or not it has changed.
+func Files(sources []Source, o *Options, fn func(File)) error {
+	report := newReport(nil)
+	var errs []error
+	for _, source := range sources {
+		s := newScanner(source, nil, o, report, newProgressTracker(), nil)
+		s.found = fn
+		err := s.scan()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func needsUpdate(db *database.DB, b backend.Backend, f *File) (bool, error) {
 	updatedTime, err := db.GetUpdatedTime(b, f.Path)
 	if err != nil {
 		return false, err
@@ -152,85 +245,255 @@ func needsUpdate(db *database.DB, b backend.Backend, f File) (bool, error) {
 		return false, nil
 	}
 
-	return true, nil
+	if _, ok := b.(*queue.Backend); !ok {
+		return true, nil
+	}
+	// don't queue the same version again while the backend is offline
+	queued, err := db.IsQueued(b.URI(), f.Path, f.Modified)
+	if err != nil {
+		return false, err
+	}
+	return !queued, nil
 }
-func backupFiles(db *database.DB, o *Options, files *stack.SyncDoneStack[File], done chan struct{}) error {
+
+// maxPending is the number of files that can be waiting for each backend
+// before reading from disk blocks.
+const maxPending = 8
+
+var errChanged = errors.New("file changed while it was being read")
+
+type upload struct {
+	file     File
+	modified time.Time
+	mode     fs.FileMode
+	data     *spool
+	// size and hash are set once the file has been read.
+	size int64
+	hash string
+	// err is set if the file couldn't be opened.
+	err  error
+	done func()
+	// retried is set if the file changed while it was read and will be
+	// uploaded again.
+	retried atomic.Bool
+}
+
+// backupFiles reads each file once and streams it to all of the backends that
+// need it. Every backend uploads from its own queue so a slow backend only
+// holds back the others once its queue is full.
+func backupFiles(db *database.DB, o *Options, files *stack.SyncDoneStack[File], report *Report, progress *progressTracker) error {
+	queues := make(map[string]chan *upload, len(o.Backends))
+	var wg sync.WaitGroup
+	for _, b := range o.Backends {
+		queue := make(chan *upload, maxPending)
+		queues[b.URI()] = queue
+		br := report.Backends[b.URI()]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				uploadFile(db, b, u, br, report, progress)
+			}
+		}()
+	}
+
 	for f := range files.All() {
-		for _, backend := range o.Backends {
-			err := backupFile(db, backend, f)
-			if err != nil {
-				slog.Error("failed to back up file", "file", f, "err", err)
+		if o.DryRun {
+			for _, backend := range f.Backends {
+				br := report.Backends[backend.URI()]
+				br.Uploaded++
+				br.BytesWritten += f.Size
+				progress.workDone.Add(fileWork(&f))
+				report.Pending = append(report.Pending, PendingFile{Backend: backend.URI(), Path: f.Path, Size: f.Size})
+				fmt.Fprintf(o.Output, "%s\t%s\t%s\n", backend.URI(), bytesize.Format(f.Size), f.Path)
 			}
-			done <- struct{}{}
+			progress.filesDone.Add(1)
+			continue
 		}
+		readFile(f, o, queues, report, progress)
+	}
+
+	for _, queue := range queues {
+		close(queue)
 	}
+	wg.Wait()
 	return nil
 }
 
-func backupFile(db *database.DB, b backend.Backend, f File) error {
-	update, err := needsUpdate(db, b, f)
-	if err != nil {
-		return err
+// readFile queues f for each of its backends and copies its compressed
+// contents to them, so the file is read and compressed once however many
+// backends need it. If the file changes while it is being read it is read
+// again.
+func readFile(f File, o *Options, queues map[string]chan *upload, report *Report, progress *progressTracker) {
+	var remaining atomic.Int32
+	remaining.Store(int32(len(f.Backends)))
+	done := func() {
+		if remaining.Add(-1) == 0 {
+			progress.filesDone.Add(1)
+		}
 	}
-	if !update {
-		return nil
+
+	for attempt := 0; ; attempt++ {
+		retry := attempt < o.ChangeRetries
+		if !readAttempt(f, queues, done, retry, report, progress) {
+			return
+		}
+		if !retry {
+			slog.Warn("file changed while it was being read", "file", f.Path)
+			report.addChanged(f.Path)
+			return
+		}
+		slog.Debug("file changed while it was being read, retrying", "file", f.Path)
 	}
-	file, err := os.Open(f.Path)
-	if err != nil {
-		return err
+}
+
+// readAttempt reads f once and reports whether it changed while it was being
+// read. If it changed and retry is true the uploads are cancelled.
+func readAttempt(f File, queues map[string]chan *upload, done func(), retry bool, report *Report, progress *progressTracker) bool {
+	readPath := f.ReadPath
+	if readPath == "" {
+		readPath = f.Path
+	}
+	file, err := os.Open(readPath)
+	var before os.FileInfo
+	if err == nil {
+		defer file.Close()
+		before, err = file.Stat()
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
+	uploads := make([]*upload, len(f.Backends))
+	writers := make([]io.Writer, len(f.Backends))
+	for i, b := range f.Backends {
+		u := &upload{file: f, err: err, done: done}
+		if err == nil {
+			u.modified = before.ModTime()
+			u.mode = before.Mode()
+			u.data = newSpool()
+			writers[i] = u.data
+		}
+		uploads[i] = u
+		queues[b.URI()] <- u
+	}
 	if err != nil {
-		return err
+		return false
 	}
 
 	slog.Debug("back up file", "file", f.Path)
-	err = b.Write(f.Path, info.ModTime(), file)
-	if err != nil {
-		return err
+	// the bytes read count as work done for every backend, a backend that
+	// falls behind holds back reading once its queue is full
+	data := &progressReader{
+		countingReader: countingReader{r: file},
+		progress:       progress,
+		backends:       int64(len(f.Backends)),
 	}
+	h := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(writers...))
+	n, err := io.Copy(io.MultiWriter(zw, h), data)
+	if err == nil {
+		err = zw.Close()
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	report.BytesRead += n
 
-	err = db.SetUpdatedTime(b, f.Path, info.ModTime())
-	if err != nil {
-		return err
+	changed := false
+	if err == nil {
+		after, statErr := file.Stat()
+		if statErr != nil {
+			after = nil
+		}
+		changed = changedWhileRead(before, after, n)
+		if changed && retry {
+			err = errChanged
+			progress.workDone.Add(-n * data.backends)
+			for _, u := range uploads {
+				u.retried.Store(true)
+			}
+		}
 	}
-	return nil
+	for _, u := range uploads {
+		u.size = n
+		u.hash = hash
+		u.data.CloseWrite(err)
+	}
+	return changed
 }
 
-var regexCache = map[string]*regexp.Regexp{}
+// changedWhileRead reports whether a file changed between the before and
+// after stats taken around reading n bytes from it. after is nil if the file
+// couldn't be stat'd once it was read.
+func changedWhileRead(before, after os.FileInfo, n int64) bool {
+	if n != before.Size() {
+		return true
+	}
+	if after == nil {
+		return false
+	}
+	return !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size()
+}
 
-func toRegex(glob string) *regexp.Regexp {
-	re, ok := regexCache[glob]
-	if !ok {
-		strRe := ""
-		if strings.HasPrefix(glob, "/") {
-			glob = glob[1:]
-			strRe += "^"
+// uploadFile writes u to b and records the result.
+func uploadFile(db *database.DB, b backend.Backend, u *upload, br *BackendReport, report *Report, progress *progressTracker) {
+	err := u.err
+	if err == nil {
+		defer u.data.abandon()
+		var written int64
+		written, err = backend.WriteCompressed(b, u.file.Path, u.modified, u.data)
+		if u.retried.Load() {
+			// the file will be queued again
+			return
 		}
-		reParts := []string{}
-		for _, part := range strings.Split(glob, "/") {
-			part = strings.ReplaceAll(part, "**", "👏")
-			part = strings.ReplaceAll(part, "*", `[^\/]*`)
-			part = strings.ReplaceAll(part, "👏", `.*`)
-			reParts = append(reParts, part)
+		// the file may have changed size since it was scanned
+		progress.workDone.Add(fileWork(&u.file) - u.size)
+		if err == nil {
+			br.Uploaded++
+			br.BytesWritten += written
+			record := &database.FileRecord{
+				Modified: u.modified,
+				Size:     u.size,
+				Hash:     u.hash,
+				Mode:     u.mode,
+				RunID:    report.RunID,
+			}
+			if _, ok := b.(*queue.Backend); ok {
+				// the file is recorded once the queue is flushed to the backend
+				err = db.SetQueuedRecord(b.URI(), u.file.Path, record)
+			} else {
+				err = db.SetFile(b, u.file.Path, record)
+			}
 		}
-		strRe += strings.Join(reParts, `\/`)
-		strRe += "$"
-
-		re = regexp.MustCompile(strRe)
-		regexCache[glob] = re
+	} else {
+		progress.workDone.Add(fileWork(&u.file))
+	}
+	defer u.done()
+	if err == nil {
+		return
 	}
 
-	return re
+	br.Failed++
+	report.addError(fmt.Errorf("failed to back up %s to %s: %w", u.file.Path, b.URI(), err))
+	slog.Error("failed to back up file", "file", u.file.Path, "err", err)
+	err = db.SetFailed(b, u.file.Path, err)
+	if err != nil {
+		slog.Error("failed to record failure", "file", u.file.Path, "err", err)
+	}
 }
 
-func matches(s string, globs []string) bool {
-	for _, glob := range globs {
-		if toRegex(glob).MatchString(s) {
-			return true
+// rootPatterns makes the ignore patterns from the config relative to dir.
+// Patterns starting with ./ or with dir itself are anchored to dir.
+func rootPatterns(dir string, patterns []string) []string {
+	result := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		prefix := ""
+		if strings.HasPrefix(p, "!") {
+			prefix = "!"
+			p = p[1:]
+		}
+		if strings.HasPrefix(p, dir+"/") {
+			p = strings.TrimPrefix(p, dir)
+		} else if strings.HasPrefix(p, "./") {
+			p = p[1:]
 		}
+		result = append(result, prefix+p)
 	}
-	return false
+	return result
 }
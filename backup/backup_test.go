@@ -2,12 +2,321 @@ package backup
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/queue"
 	"github.com/gobwas/glob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestBackupRecordsFiles(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("hello")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), data, 0640))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+	b := backend.NewFile(t.TempDir())
+
+	report, err := Backup(db, []Source{{Dir: dir}}, &Options{
+		Backends: []backend.Backend{b},
+		Progress: func(Progress) {},
+	})
+	require.NoError(t, err)
+
+	f, err := db.GetFile(b, filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), f.Hash)
+	assert.Equal(t, int64(len(data)), f.Size)
+	assert.Equal(t, os.FileMode(0640), f.Mode)
+	assert.Equal(t, report.RunID, f.RunID)
+	assert.Len(t, f.Versions, 1)
+}
+
+func TestBackupDryRun(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+	b := backend.NewFile(t.TempDir())
+
+	report, err := Backup(db, []Source{{Dir: dir}}, &Options{
+		Backends: []backend.Backend{b},
+		DryRun:   true,
+		Output:   io.Discard,
+		Progress: func(Progress) {},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []PendingFile{{Backend: b.URI(), Path: filepath.Join(dir, "a.txt"), Size: 5}}, report.Pending)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"pending":[{"backend":`)
+}
+
+func TestBackupMultipleBackends(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("hello "), 1000)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), data, 0644))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+	backends := []backend.Backend{backend.NewFile(t.TempDir()), backend.NewFile(t.TempDir())}
+
+	report, err := Backup(db, []Source{{Dir: dir}}, &Options{
+		Backends: backends,
+		Progress: func(Progress) {},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), report.BytesRead)
+
+	for _, b := range backends {
+		f, err := b.Read(filepath.Join(dir, "a.txt"))
+		require.NoError(t, err)
+		r, err := f.Data(f.Versions()[0])
+		require.NoError(t, err)
+		stored, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data, stored)
+		assert.Equal(t, f.Size(f.Versions()[0]), report.Backends[b.URI()].BytesWritten)
+	}
+}
+
+func TestBackupMarksDeleted(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.log"), []byte("b"), 0644))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+	b := backend.NewFile(t.TempDir())
+
+	_, err = Backup(db, []Source{{Dir: dir}}, &Options{
+		Backends: []backend.Backend{b},
+		Progress: func(Progress) {},
+	})
+	require.NoError(t, err)
+
+	// a file that is now ignored is still on disk
+	require.NoError(t, os.Remove(filepath.Join(dir, "a.txt")))
+	report, err := Backup(db, []Source{{Dir: dir}}, &Options{
+		Backends: []backend.Backend{b},
+		Ignore:   []string{"*.log"},
+		Progress: func(Progress) {},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Backends[b.URI()].Deleted)
+
+	f, err := db.GetFile(b, filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	f, err = db.GetFile(b, filepath.Join(dir, "b.log"))
+	require.NoError(t, err)
+	assert.NotNil(t, f)
+}
+
+func TestBackupNewDestination(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	backupTo := func(b backend.Backend) *BackendReport {
+		repository, err := backend.Identity(b)
+		require.NoError(t, err)
+		require.NoError(t, db.SetBackendURI("backups", b.URI(), repository))
+		report, err := Backup(db, []Source{{Dir: dir}}, &Options{
+			Backends: []backend.Backend{b},
+			Progress: func(Progress) {},
+		})
+		require.NoError(t, err)
+		return report.Backends[b.URI()]
+	}
+
+	assert.Equal(t, 2, backupTo(backend.NewFile(t.TempDir())).Uploaded)
+	// pointing the same config entry at an empty directory uploads everything
+	// again
+	assert.Equal(t, 2, backupTo(backend.NewFile(t.TempDir())).Uploaded)
+}
+
+func TestBackupQueued(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+	b := backend.NewFile(t.TempDir())
+	q := queue.New(db, b.URI(), "")
+
+	backupTo := func(b backend.Backend) *BackendReport {
+		report, err := Backup(db, []Source{{Dir: dir}}, &Options{
+			Backends: []backend.Backend{b},
+			Progress: func(Progress) {},
+		})
+		require.NoError(t, err)
+		return report.Backends[b.URI()]
+	}
+
+	assert.Equal(t, 1, backupTo(q).Uploaded)
+	// queued files aren't recorded until they reach the backend
+	f, err := db.GetFile(b, filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	// or queued again while it is offline
+	assert.Equal(t, 0, backupTo(q).Uploaded)
+
+	require.NoError(t, queue.Flush(db, b))
+	f, err = db.GetFile(b, filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	assert.Equal(t, int64(1), f.Size)
+	assert.Equal(t, 0, backupTo(b).Uploaded)
+}
+
+func TestCheckSources(t *testing.T) {
+	testCases := []struct {
+		name  string
+		dirs  []string
+		valid bool
+	}{
+		{"one", []string{"/home"}, true},
+		{"separate", []string{"/home", "/srv"}, true},
+		{"shared prefix", []string{"/home/me", "/home/meg"}, true},
+		{"duplicate", []string{"/home", "/srv", "/home"}, false},
+		{"nested", []string{"/home", "/home/me"}, false},
+		{"nested first", []string{"/home/me/docs", "/home"}, false},
+		{"root", []string{"/", "/home"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sources := make([]Source, len(tc.dirs))
+			for i, dir := range tc.dirs {
+				sources[i] = Source{Dir: dir}
+			}
+			err := CheckSources(sources)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+type fileInfo struct {
+	size     int64
+	modified time.Time
+}
+
+func (f fileInfo) Name() string       { return "a.txt" }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() fs.FileMode  { return 0644 }
+func (f fileInfo) ModTime() time.Time { return f.modified }
+func (f fileInfo) IsDir() bool        { return false }
+func (f fileInfo) Sys() any           { return nil }
+
+func TestChangedWhileRead(t *testing.T) {
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+
+	testCases := []struct {
+		name     string
+		before   fs.FileInfo
+		after    fs.FileInfo
+		n        int64
+		expected bool
+	}{
+		{"unchanged", fileInfo{5, t1}, fileInfo{5, t1}, 5, false},
+		{"short read", fileInfo{5, t1}, fileInfo{5, t1}, 3, true},
+		{"long read", fileInfo{5, t1}, fileInfo{5, t1}, 7, true},
+		{"grew", fileInfo{5, t1}, fileInfo{7, t2}, 5, true},
+		{"rewritten with the same size", fileInfo{5, t1}, fileInfo{5, t2}, 5, true},
+		{"stat failed", fileInfo{5, t1}, nil, 5, false},
+		{"stat failed after a short read", fileInfo{5, t1}, nil, 3, true},
+		// files in procfs and sysfs report a size of 0
+		{"reported size is wrong", fileInfo{0, t1}, fileInfo{0, t1}, 100, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, changedWhileRead(tc.before, tc.after, tc.n))
+		})
+	}
+}
+
+func TestReadFileRetries(t *testing.T) {
+	stable := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(stable, []byte("hello"), 0644))
+	// reads of a procfs file never match its size, so it always looks like it
+	// changed
+	changing := "/proc/self/stat"
+
+	testCases := []struct {
+		name     string
+		path     string
+		retries  int
+		attempts int
+		changed  bool
+	}{
+		{"stable", stable, 0, 1, false},
+		{"stable with retries", stable, 2, 1, false},
+		{"changing", changing, 0, 1, true},
+		{"changing with retries", changing, 2, 3, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := os.Stat(tc.path); err != nil {
+				t.Skip("procfs isn't available")
+			}
+			b := backend.NewFile(t.TempDir())
+			queue := make(chan *upload, 10)
+			report := newReport([]backend.Backend{b})
+
+			readFile(File{Path: tc.path, Backends: []backend.Backend{b}}, &Options{ChangeRetries: tc.retries}, map[string]chan *upload{b.URI(): queue}, report, newProgressTracker())
+			close(queue)
+
+			uploads := []*upload{}
+			for u := range queue {
+				require.NoError(t, u.err)
+				u.data.abandon()
+				uploads = append(uploads, u)
+			}
+			require.Len(t, uploads, tc.attempts)
+			for _, u := range uploads[:len(uploads)-1] {
+				assert.True(t, u.retried.Load(), "earlier attempts are cancelled")
+			}
+			assert.False(t, uploads[len(uploads)-1].retried.Load(), "the last attempt is kept")
+			if tc.changed {
+				assert.Equal(t, []string{tc.path}, report.Changed)
+			} else {
+				assert.Empty(t, report.Changed)
+			}
+		})
+	}
+}
+
 func BenchmarkRegex(b *testing.B) {
 	re := regexp.MustCompile("node_modules")
 	files := [][]byte{
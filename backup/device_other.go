@@ -0,0 +1,12 @@
+//go:build !unix
+
+package backup
+
+import (
+	"io/fs"
+)
+
+// device is not supported on this platform so one-file-system has no effect.
+func device(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package backup
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// device returns the id of the device a file is stored on.
+func device(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/abibby/backup/bytesize"
+)
+
+// fileOverhead is the amount of work, in bytes, added for each file on top of
+// its size so that the estimate accounts for the per file cost of uploading
+// many small files.
+const fileOverhead = 4096
+
+type Progress struct {
+	// Scanning is true while files are still being found so the totals may
+	// grow.
+	Scanning   bool
+	Scanned    int64
+	FilesTotal int64
+	FilesDone  int64
+	BytesTotal int64
+	BytesDone  int64
+	Elapsed    time.Duration
+	// Remaining is the estimated time left, 0 if it is not known yet.
+	Remaining time.Duration
+}
+
+type progressTracker struct {
+	start      time.Time
+	scanning   atomic.Bool
+	scanned    atomic.Int64
+	filesTotal atomic.Int64
+	filesDone  atomic.Int64
+	bytesTotal atomic.Int64
+	// work counts the bytes read once for every backend the file is uploaded
+	// to, plus fileOverhead for each one.
+	workTotal atomic.Int64
+	workDone  atomic.Int64
+}
+
+func newProgressTracker() *progressTracker {
+	p := &progressTracker{
+		start: time.Now(),
+	}
+	p.scanning.Store(true)
+	return p
+}
+
+func fileWork(f *File) int64 {
+	return f.Size + fileOverhead
+}
+
+func (p *progressTracker) addFile(f *File) {
+	p.filesTotal.Add(1)
+	p.bytesTotal.Add(f.Size)
+	p.workTotal.Add(fileWork(f) * int64(len(f.Backends)))
+}
+
+func (p *progressTracker) snapshot() Progress {
+	elapsed := time.Since(p.start)
+	workTotal := p.workTotal.Load()
+	workDone := p.workDone.Load()
+	bytesTotal := p.bytesTotal.Load()
+
+	progress := Progress{
+		Scanning:   p.scanning.Load(),
+		Scanned:    p.scanned.Load(),
+		FilesTotal: p.filesTotal.Load(),
+		FilesDone:  p.filesDone.Load(),
+		BytesTotal: bytesTotal,
+		Elapsed:    elapsed,
+	}
+	if workTotal > 0 {
+		progress.BytesDone = int64(float64(bytesTotal) * float64(workDone) / float64(workTotal))
+	}
+	if workDone > 0 {
+		progress.Remaining = time.Duration(float64(elapsed) * float64(workTotal-workDone) / float64(workDone))
+	}
+	return progress
+}
+
+// report calls the progress callback every second until done is closed. A
+// callback set in the options is called once more with the final progress
+// before it returns.
+func (p *progressTracker) report(o *Options, done chan struct{}) {
+	callback := o.Progress
+	if callback == nil {
+		callback = logProgress
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			if o.Progress != nil {
+				o.Progress(p.snapshot())
+			}
+			return
+		case <-ticker.C:
+			callback(p.snapshot())
+		}
+	}
+}
+
+func logProgress(p Progress) {
+	slog.Info("backing up",
+		"scanning", p.Scanning,
+		"total", p.FilesTotal,
+		"done", p.FilesDone,
+		"bytes", bytesize.Format(p.BytesDone)+"/"+bytesize.Format(p.BytesTotal),
+		"remaining", p.Remaining.Truncate(time.Second),
+		"end", time.Now().Add(p.Remaining).Truncate(time.Second),
+	)
+}
+
+// progressReader counts the bytes read as work done for each of the backends
+// they are written to.
+type progressReader struct {
+	countingReader
+	progress *progressTracker
+	backends int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.countingReader.Read(p)
+	r.progress.workDone.Add(int64(n) * r.backends)
+	return n, err
+}
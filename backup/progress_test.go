@@ -0,0 +1,38 @@
+package backup
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressReportFinalUpdate(t *testing.T) {
+	p := newProgressTracker()
+	var active, calls atomic.Int32
+	ticked := make(chan struct{}, 1)
+	o := &Options{Progress: func(Progress) {
+		assert.Equal(t, int32(1), active.Add(1), "progress callbacks overlap")
+		select {
+		case ticked <- struct{}{}:
+		default:
+		}
+		time.Sleep(100 * time.Millisecond)
+		calls.Add(1)
+		active.Add(-1)
+	}}
+
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		p.report(o, done)
+	}()
+
+	// finish while a tick is being reported
+	<-ticked
+	close(done)
+	<-reported
+	assert.Equal(t, int32(2), calls.Load())
+}
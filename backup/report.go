@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abibby/backup/backend"
+)
+
+type Report struct {
+	// RunID identifies the run in the file records it writes.
+	RunID    string
+	Start    time.Time
+	Duration time.Duration
+	// DryRun is true if nothing was written. Uploaded and BytesWritten count
+	// what would have been uploaded.
+	DryRun bool
+	// Scanned is the number of files found while scanning the directory.
+	Scanned int
+	// Unchanged is the number of scanned files that were already backed up to
+	// every backend.
+	Unchanged int
+	// BytesRead is the number of bytes read from disk. Each file is read once
+	// however many backends it is uploaded to.
+	BytesRead int64
+	Backends  map[string]*BackendReport
+	// Errors holds the first maxErrors errors that happened during the run.
+	Errors []string
+	// Changed holds the first maxErrors files that kept changing while they
+	// were being read, their backed up copies may be inconsistent.
+	Changed []string
+	// Pending lists the files a dry run would upload.
+	Pending []PendingFile
+
+	mtx sync.Mutex
+}
+
+// A PendingFile is a file that a dry run would upload to a backend.
+type PendingFile struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+}
+
+type BackendReport struct {
+	Uploaded int `json:"uploaded"`
+	Failed   int `json:"failed"`
+	// BytesWritten is the number of bytes the backend stored. It is the
+	// compressed size for backends that store compressed versions and the
+	// full size for ones that don't.
+	BytesWritten int64 `json:"bytes_written"`
+	// Deleted is the number of backed up files that were no longer found on
+	// disk.
+	Deleted int `json:"deleted"`
+	// Error is set if the backend couldn't be used for the whole run.
+	Error string `json:"error,omitempty"`
+}
+
+func newReport(backends []backend.Backend) *Report {
+	r := &Report{
+		RunID:    newRunID(),
+		Start:    time.Now(),
+		Backends: make(map[string]*BackendReport, len(backends)),
+	}
+	for _, b := range backends {
+		r.Backends[b.URI()] = &BackendReport{}
+	}
+	return r
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (r *Report) Uploaded() int {
+	total := 0
+	for _, b := range r.Backends {
+		total += b.Uploaded
+	}
+	return total
+}
+
+func (r *Report) Failed() int {
+	total := 0
+	for _, b := range r.Backends {
+		total += b.Failed
+	}
+	return total
+}
+
+func (r *Report) BytesWritten() int64 {
+	total := int64(0)
+	for _, b := range r.Backends {
+		total += b.BytesWritten
+	}
+	return total
+}
+
+const maxErrors = 100
+
+func (r *Report) addError(err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.Errors) < maxErrors {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+func (r *Report) addChanged(path string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.Changed) < maxErrors {
+		r.Changed = append(r.Changed, path)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *Report) MarshalJSON() ([]byte, error) {
+	m := map[string]any{
+		"run_id":           r.RunID,
+		"start":            r.Start,
+		"duration_seconds": r.Duration.Seconds(),
+		"dry_run":          r.DryRun,
+		"scanned":          r.Scanned,
+		"unchanged":        r.Unchanged,
+		"bytes_read":       r.BytesRead,
+		"uploaded":         r.Uploaded(),
+		"failed":           r.Failed(),
+		"bytes_written":    r.BytesWritten(),
+		"backends":         r.Backends,
+		"errors":           r.Errors,
+		"changed":          r.Changed,
+	}
+	if r.DryRun {
+		pending := r.Pending
+		if pending == nil {
+			pending = []PendingFile{}
+		}
+		m["pending"] = pending
+	}
+	return json.Marshal(m)
+}
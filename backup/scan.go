@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/ignore"
+	"github.com/abibby/backup/stack"
+)
+
+type scanner struct {
+	root string
+	// readRoot is the directory the files are read from, either root or a
+	// snapshot of it.
+	readRoot string
+	ignore   []string
+	db       *database.DB
+	o        *Options
+	report   *Report
+	progress *progressTracker
+	files    *stack.SyncDoneStack[File]
+	include  *ignore.Matcher
+	device   uint64
+	// found is called with every file instead of queueing it if it is set.
+	found func(File)
+	// seen holds every file found on disk, including ones skipped by the
+	// filters. incomplete is set if part of the tree couldn't be read.
+	seen       map[string]bool
+	incomplete bool
+}
+
+func newScanner(source Source, db *database.DB, o *Options, report *Report, progress *progressTracker, files *stack.SyncDoneStack[File]) *scanner {
+	root := source.Dir
+	s := &scanner{
+		root:     root,
+		readRoot: root,
+		ignore:   rootPatterns(root, append(slices.Clone(o.Ignore), source.Ignore...)),
+		db:       db,
+		o:        o,
+		report:   report,
+		progress: progress,
+		files:    files,
+		seen:     map[string]bool{},
+	}
+	if source.Snapshot != "" {
+		s.readRoot = source.Snapshot
+	}
+	if len(o.Include) > 0 {
+		s.include = ignore.New(rootPatterns(root, o.Include))
+	}
+	return s
+}
+
+func (s *scanner) scan() error {
+	if s.o.OneFileSystem {
+		info, err := os.Stat(s.readRoot)
+		if err != nil {
+			return fmt.Errorf("failed to load directory %s: %w", s.readRoot, err)
+		}
+		s.device, _ = device(info)
+	}
+	m := ignore.New(s.ignore)
+	return s.scanFolder(s.readRoot, "", m, s.include == nil)
+}
+
+// scanFolder queues every file in dir that should be backed up. rel is dir
+// relative to the root of the scan and included is true if dir matched an
+// include rule.
+func (s *scanner) scanFolder(dir, rel string, m *ignore.Matcher, included bool) error {
+	var err error
+	files, err := os.ReadDir(dir)
+
+	if err != nil {
+		return fmt.Errorf("failed to load directory %s: %w", dir, err)
+	}
+
+	for _, f := range files {
+		if slices.Contains(s.o.ExcludeIfPresent, f.Name()) {
+			slog.Debug("skipping directory", "dir", dir, "marker", f.Name())
+			return nil
+		}
+	}
+
+	if s.o.IgnoreFile != "" {
+		patterns, err := ignore.ReadFile(path.Join(dir, s.o.IgnoreFile))
+		if err == nil {
+			m = m.Add(rel, patterns)
+		} else if !os.IsNotExist(err) {
+			slog.Warn("failed to read ignore file", "dir", dir, "err", err)
+		}
+	}
+
+	for _, f := range files {
+		p := path.Join(dir, f.Name())
+		r := ignore.Join(rel, f.Name())
+		if m.Match(r, f.IsDir()) {
+			continue
+		}
+		fileIncluded := included || s.include.Match(r, f.IsDir())
+		if f.IsDir() {
+			if backend.Reserved(path.Join(s.root, r)) {
+				slog.Warn("skipping directory, its path is used by the backends' metadata", "dir", p)
+				continue
+			}
+			if s.o.OneFileSystem && !s.sameDevice(f) {
+				slog.Debug("skipping mount point", "dir", p)
+				continue
+			}
+			err = s.scanFolder(p, r, m, fileIncluded)
+			if err != nil {
+				s.incomplete = true
+				slog.Error("failed to backup file", "file", p, "err", err)
+				s.report.addError(err)
+			}
+		} else if f.Type()&os.ModeSymlink != 0 {
+			// ignore symlinks
+		} else if !f.Type().IsRegular() {
+			// ignore sockets, fifos, devices and other special files
+			slog.Debug("skipping special file", "file", p, "type", f.Type())
+		} else if fileIncluded {
+			readPath := ""
+			if s.readRoot != s.root {
+				readPath = p
+				p = path.Join(s.root, r)
+			}
+			s.seen[p] = true
+			info, err := f.Info()
+			if err != nil {
+				s.incomplete = true
+				slog.Error("failed to queue file", "file", p, "err", err)
+				s.report.addError(fmt.Errorf("failed to queue file %s: %w", p, err))
+				continue
+			}
+			if s.skip(info) {
+				continue
+			}
+			s.queue(&File{
+				Path:     p,
+				ReadPath: readPath,
+				Modified: info.ModTime(),
+				Size:     info.Size(),
+			})
+		}
+	}
+	return nil
+}
+
+// queue sends f to be backed up to each backend that needs it.
+func (s *scanner) queue(f *File) {
+	if s.found != nil {
+		s.found(*f)
+		return
+	}
+	s.report.Scanned++
+	s.progress.scanned.Add(1)
+	for _, b := range s.o.Backends {
+		update, err := needsUpdate(s.db, b, f)
+		if err != nil {
+			slog.Error("failed to check file", "file", f.Path, "err", err)
+			s.report.addError(fmt.Errorf("failed to check %s: %w", f.Path, err))
+			continue
+		}
+		if update {
+			f.Backends = append(f.Backends, b)
+		}
+	}
+	if len(f.Backends) == 0 {
+		s.report.Unchanged++
+		return
+	}
+	s.progress.addFile(f)
+	s.files.Push(*f)
+}
+
+// skip reports whether a file is excluded by the size or age filters.
+func (s *scanner) skip(info fs.FileInfo) bool {
+	if s.o.MaxSize > 0 && info.Size() > s.o.MaxSize {
+		return true
+	}
+	if s.o.MinSize > 0 && info.Size() < s.o.MinSize {
+		return true
+	}
+	if !s.o.NewerThan.IsZero() && info.ModTime().Before(s.o.NewerThan) {
+		return true
+	}
+	return false
+}
+
+func (s *scanner) sameDevice(f fs.DirEntry) bool {
+	info, err := f.Info()
+	if err != nil {
+		return true
+	}
+	d, ok := device(info)
+	if !ok {
+		return true
+	}
+	return d == s.device
+}
@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTree creates the files in the map, keyed by their path relative to
+// dir, with the given contents.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(data), 0644))
+	}
+}
+
+// scanFiles returns the paths of the files a backup of sources would
+// consider, relative to root and sorted.
+func scanFiles(t *testing.T, root string, sources []Source, o *Options) []string {
+	t.Helper()
+	paths := []string{}
+	err := Files(sources, o, func(f File) {
+		rel, err := filepath.Rel(root, f.Path)
+		require.NoError(t, err)
+		paths = append(paths, filepath.ToSlash(rel))
+	})
+	require.NoError(t, err)
+	slices.Sort(paths)
+	return paths
+}
+
+func TestScanFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.txt":     strings.Repeat("a", 10),
+		"b.log":     strings.Repeat("b", 100),
+		"sub/c.txt": strings.Repeat("c", 1000),
+		"sub/d.log": "d",
+	})
+
+	testCases := []struct {
+		name     string
+		options  Options
+		expected []string
+	}{
+		{"none", Options{}, []string{"a.txt", "b.log", "sub/c.txt", "sub/d.log"}},
+		{"include pattern", Options{Include: []string{"*.txt"}}, []string{"a.txt", "sub/c.txt"}},
+		{"include directory", Options{Include: []string{"sub"}}, []string{"sub/c.txt", "sub/d.log"}},
+		{"include rooted", Options{Include: []string{"/a.txt"}}, []string{"a.txt"}},
+		{"ignore beats include", Options{Include: []string{"*.txt"}, Ignore: []string{"sub"}}, []string{"a.txt"}},
+		{"min size", Options{MinSize: 50}, []string{"b.log", "sub/c.txt"}},
+		{"max size", Options{MaxSize: 50}, []string{"a.txt", "sub/d.log"}},
+		{"min and max size", Options{MinSize: 50, MaxSize: 500}, []string{"b.log"}},
+		{"sizes are inclusive", Options{MinSize: 10, MaxSize: 100}, []string{"a.txt", "b.log"}},
+		{"include and size", Options{Include: []string{"*.txt"}, MinSize: 50}, []string{"sub/c.txt"}},
+		{"one file system", Options{OneFileSystem: true}, []string{"a.txt", "b.log", "sub/c.txt", "sub/d.log"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, scanFiles(t, dir, []Source{{Dir: dir}}, &tc.options))
+		})
+	}
+}
+
+func TestScanOneFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"sub/a.txt": "a"})
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	dev, ok := device(info)
+	if !ok {
+		t.Skip("devices aren't supported on this platform")
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	testCases := []struct {
+		name     string
+		device   uint64
+		expected bool
+	}{
+		{"same device", dev, true},
+		{"other device", dev + 1, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &scanner{device: tc.device}
+			assert.Equal(t, tc.expected, s.sameDevice(entries[0]))
+		})
+	}
+}
+
+func TestScanSourceIgnores(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a.txt":     "a",
+		"b.log":     "b",
+		"tmp/c.txt": "c",
+	}
+	one := filepath.Join(root, "one")
+	two := filepath.Join(root, "two")
+	writeTree(t, one, files)
+	writeTree(t, two, files)
+
+	testCases := []struct {
+		name     string
+		sources  []Source
+		ignore   []string
+		expected []string
+	}{
+		{
+			name:    "no ignores",
+			sources: []Source{{Dir: one}, {Dir: two}},
+			expected: []string{
+				"one/a.txt", "one/b.log", "one/tmp/c.txt",
+				"two/a.txt", "two/b.log", "two/tmp/c.txt",
+			},
+		},
+		{
+			name:    "source ignores only apply to their source",
+			sources: []Source{{Dir: one, Ignore: []string{"*.log"}}, {Dir: two, Ignore: []string{"tmp"}}},
+			expected: []string{
+				"one/a.txt", "one/tmp/c.txt",
+				"two/a.txt", "two/b.log",
+			},
+		},
+		{
+			name:    "rooted patterns are relative to the source",
+			sources: []Source{{Dir: one, Ignore: []string{"/a.txt"}}, {Dir: two, Ignore: []string{"/tmp/c.txt"}}},
+			expected: []string{
+				"one/b.log", "one/tmp/c.txt",
+				"two/a.txt", "two/b.log",
+			},
+		},
+		{
+			name:    "global ignores apply to every source",
+			sources: []Source{{Dir: one, Ignore: []string{"tmp"}}, {Dir: two}},
+			ignore:  []string{"*.log"},
+			expected: []string{
+				"one/a.txt",
+				"two/a.txt", "two/tmp/c.txt",
+			},
+		},
+		{
+			name:    "source negation overrides a global ignore",
+			sources: []Source{{Dir: one, Ignore: []string{"!b.log"}}, {Dir: two}},
+			ignore:  []string{"*.log"},
+			expected: []string{
+				"one/a.txt", "one/b.log", "one/tmp/c.txt",
+				"two/a.txt", "two/tmp/c.txt",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, scanFiles(t, root, tc.sources, &Options{Ignore: tc.ignore}))
+		})
+	}
+}
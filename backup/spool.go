@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// spoolMemory is the number of unread bytes a spool holds in memory before
+// it spills to a temporary file.
+const spoolMemory = 4 << 20
+
+// A spool is a pipe whose writes never block. Data that the reader hasn't
+// caught up with is kept in memory and then in a temporary file so a slow
+// reader doesn't hold back the writer.
+type spool struct {
+	mtx  sync.Mutex
+	cond *sync.Cond
+
+	chunks [][]byte
+	memory int
+
+	// once the spool spills every following write goes to file
+	file    *os.File
+	written int64
+	read    int64
+
+	closed    bool
+	err       error
+	abandoned bool
+}
+
+func newSpool() *spool {
+	s := &spool{}
+	s.cond = sync.NewCond(&s.mtx)
+	return s
+}
+
+// Write buffers p. Errors are returned to the reader instead of the writer so
+// one failing spool doesn't stop the others it is written alongside.
+func (s *spool) Write(p []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	defer s.cond.Broadcast()
+
+	if s.abandoned || s.closed {
+		return len(p), nil
+	}
+
+	if s.file == nil && s.memory+len(p) <= spoolMemory {
+		s.chunks = append(s.chunks, append([]byte(nil), p...))
+		s.memory += len(p)
+		return len(p), nil
+	}
+
+	if s.file == nil {
+		f, err := os.CreateTemp("", "backup-spool-")
+		if err != nil {
+			s.closed, s.err = true, err
+			return len(p), nil
+		}
+		s.file = f
+	}
+	n, err := s.file.WriteAt(p, s.written)
+	s.written += int64(n)
+	if err != nil {
+		s.closed, s.err = true, err
+	}
+	return len(p), nil
+}
+
+func (s *spool) Read(p []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for {
+		if len(s.chunks) > 0 {
+			n := copy(p, s.chunks[0])
+			s.chunks[0] = s.chunks[0][n:]
+			if len(s.chunks[0]) == 0 {
+				s.chunks[0] = nil
+				s.chunks = s.chunks[1:]
+			}
+			s.memory -= n
+			return n, nil
+		}
+		if s.file != nil && s.read < s.written {
+			n := int64(len(p))
+			if remaining := s.written - s.read; remaining < n {
+				n = remaining
+			}
+			m, err := s.file.ReadAt(p[:n], s.read)
+			s.read += int64(m)
+			if err == io.EOF {
+				err = nil
+			}
+			return m, err
+		}
+		if s.closed {
+			if s.err != nil {
+				return 0, s.err
+			}
+			return 0, io.EOF
+		}
+		s.cond.Wait()
+	}
+}
+
+// CloseWrite marks the end of the data. If err is not nil the reader gets it
+// once it has read everything before it.
+func (s *spool) CloseWrite(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.closed {
+		s.closed, s.err = true, err
+	}
+	s.cond.Broadcast()
+}
+
+// abandon is called by the reader when it is finished. Any data left is
+// discarded along with anything written later.
+func (s *spool) abandon() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.abandoned = true
+	s.chunks = nil
+	s.memory = 0
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+}
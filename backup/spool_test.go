@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	data := make([]byte, spoolMemory*2+123)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	s := newSpool()
+	defer s.abandon()
+
+	// write everything before reading so the spool has to spill to disk
+	_, err := io.CopyBuffer(s, bytes.NewReader(data), make([]byte, 1000))
+	require.NoError(t, err)
+	s.CloseWrite(nil)
+
+	result, err := io.ReadAll(s)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, result))
+}
+
+func TestSpoolError(t *testing.T) {
+	s := newSpool()
+	defer s.abandon()
+
+	go func() {
+		s.Write([]byte("abc"))
+		s.CloseWrite(errors.New("read failed"))
+	}()
+
+	result, err := io.ReadAll(s)
+	assert.EqualError(t, err, "read failed")
+	assert.Equal(t, "abc", string(result))
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/queue"
+)
+
+// StreamPath returns the path a stream with the given name is stored at.
+func StreamPath(name string) string {
+	return path.Join("/", name)
+}
+
+// WriteStream writes data to every backend as a version of the virtual file
+// name. data is read once and copied to a temporary file if it can't be
+// rewound for each backend.
+func WriteStream(backends []backend.Backend, name string, t time.Time, data io.Reader) error {
+	p := StreamPath(name)
+	if backend.Reserved(p) {
+		return fmt.Errorf("the stream name %s is reserved for the backends' metadata", name)
+	}
+
+	rs, ok := data.(io.ReadSeeker)
+	if ok {
+		// pipes such as stdin implement io.Seeker but fail when used
+		_, err := rs.Seek(0, io.SeekCurrent)
+		ok = err == nil
+	}
+	if !ok {
+		f, err := os.CreateTemp("", "backup-stream-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		_, err = io.Copy(f, data)
+		if err != nil {
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+		rs = f
+	}
+
+	var errs []error
+	for _, b := range backends {
+		_, err := rs.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		slog.Debug("back up stream", "name", p, "backend", b.URI())
+		if q, ok := b.(*queue.Backend); ok {
+			err = q.WriteVirtual(p, t, rs)
+		} else {
+			err = b.Write(p, t, rs)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to write %s to %s: %w", p, b.URI(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package bytesize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	B   int64 = 1
+	KB  int64 = 1000
+	MB  int64 = 1000 * KB
+	GB  int64 = 1000 * MB
+	TB  int64 = 1000 * GB
+	KiB int64 = 1024
+	MiB int64 = 1024 * KiB
+	GiB int64 = 1024 * MiB
+	TiB int64 = 1024 * GiB
+)
+
+var units = map[string]int64{
+	"":    B,
+	"b":   B,
+	"k":   KiB,
+	"kb":  KB,
+	"kib": KiB,
+	"m":   MiB,
+	"mb":  MB,
+	"mib": MiB,
+	"g":   GiB,
+	"gb":  GB,
+	"gib": GiB,
+	"t":   TiB,
+	"tb":  TB,
+	"tib": TiB,
+}
+
+// Parse converts a human readable size such as "5MiB", "10 MB" or "512" into
+// a number of bytes.
+func Parse(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	multiplier, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit %q", s[i:])
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// Format converts a number of bytes into a human readable size using binary
+// units.
+func Format(n int64) string {
+	if n < KiB {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= 1024
+		if value < 1024 || unit == "TiB" {
+			return fmt.Sprintf("%.1f%s", value, unit)
+		}
+	}
+	return ""
+}
@@ -17,12 +17,28 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/abibby/backup/backend"
 	"github.com/abibby/backup/backup"
+	"github.com/abibby/backup/bytesize"
 	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/hooks"
+	"github.com/abibby/backup/hosts"
+	"github.com/abibby/backup/lock"
+	"github.com/abibby/backup/metrics"
+	"github.com/abibby/backup/notify"
+	"github.com/abibby/backup/queue"
+	"github.com/abibby/backup/snapshot"
+	"github.com/abibby/backup/state"
+	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -34,6 +50,13 @@ var backupCmd = &cobra.Command{
 	Short: "Initiate a backup to the backup server",
 	Long:  ``,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return dryRunBackup()
+		}
 		return runBackup()
 	},
 }
@@ -41,41 +64,520 @@ var backupCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(backupCmd)
 
+	backupCmd.Flags().Bool("dry-run", false, "list the files that would be uploaded without uploading them")
+	backupCmd.Flags().BoolVar(&jsonOutput, "json", false, "write progress and the final report to stdout as JSON lines")
+	backupCmd.Flags().BoolVar(&progressBar, "progress", false, "show a progress bar")
+
 	viper.SetDefault("ignore", []string{})
+	viper.SetDefault("ignore-file", ".backupignore")
+	viper.SetDefault("exclude-if-present", []string{})
+	viper.SetDefault("include", []string{})
+	viper.SetDefault("one-file-system", false)
+	viper.SetDefault("change-retries", 2)
 	viper.SetDefault("backends", []string{})
+	viper.SetDefault("staging", "")
+	viper.SetDefault("tags", []string{})
+	viper.SetDefault("stateless", false)
+}
+
+// getBackupBackends loads the configured backends, locks them and flushes any
+// files that were queued for them. Backends that can't be reached or are
+// locked by another process are replaced with a queue so that the files that
+// change in this run are backed up once they come back. If the database is
+// missing or older than the state stored in a backend it is restored first.
+// The returned function releases the locks.
+func getBackupBackends(db *database.DB, dryRun bool) ([]backend.Backend, func(), error) {
+	loaded, err := loadBackends()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	locks := []*lock.Held{}
+	release := func() {
+		for _, l := range locks {
+			err := l.Release()
+			if err != nil {
+				slog.Error("failed to release lock", "lock", l.ID, "err", err)
+			}
+		}
+	}
+
+	backends := []backend.Backend{}
+	for _, l := range loaded {
+		if l.Err == nil && !dryRun {
+			held, err := lock.Acquire(l.Backend, "backup", false)
+			if err != nil {
+				closeBackend(l.Backend)
+				l.Err = err
+			} else if held != nil {
+				locks = append(locks, held)
+			}
+		}
+
+		if l.Err != nil {
+			uri, err := db.GetBackendURI(l.Key)
+			if err != nil {
+				release()
+				return nil, nil, err
+			}
+			if uri == "" {
+				slog.Error("failed to load backend", "backend", l.Key, "err", l.Err)
+				continue
+			}
+			slog.Warn("backend unavailable, queueing changes", "backend", l.Key, "err", l.Err)
+			backends = append(backends, queue.New(db, uri, viper.GetString("staging")))
+			continue
+		}
+
+		backends = append(backends, l.Backend)
+		if dryRun {
+			continue
+		}
+
+		repository, err := backend.Identity(l.Backend)
+		if err != nil {
+			slog.Error("failed to read backend identity", "backend", l.Key, "err", err)
+		}
+		err = db.SetBackendURI(l.Key, l.Backend.URI(), repository)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		err = state.Sync(db, l.Backend)
+		if err != nil {
+			slog.Error("failed to sync local database with backend", "backend", l.Key, "err", err)
+		}
+		err = queue.Flush(db, l.Backend)
+		if err != nil {
+			slog.Error("failed to flush queued files", "backend", l.Key, "err", err)
+		}
+	}
+	return backends, release, nil
 }
 
 func runBackup() error {
-	dir, err := filepath.Abs(viper.GetString("dir"))
+	start := time.Now()
+
+	db, err := database.Open(databasePath())
 	if err != nil {
+		err = errors.Wrap(err, "failed to initialize database")
+		finishRun(nil, &backup.Report{Start: start, Duration: time.Since(start)}, err)
 		return err
 	}
 
-	slog.Info("Staring backup", "directory", dir)
+	defer db.Close()
+
+	report, err := backupDB(db)
+	if report == nil {
+		report = &backup.Report{Start: start, Duration: time.Since(start)}
+	}
+	finishRun(db, report, err)
+	return err
+}
 
-	db, err := database.Open(viper.GetString("database"))
+func getBackupOptions() (*backup.Options, error) {
+	o := &backup.Options{
+		Ignore:           viper.GetStringSlice("ignore"),
+		IgnoreFile:       viper.GetString("ignore-file"),
+		ExcludeIfPresent: viper.GetStringSlice("exclude-if-present"),
+		Include:          viper.GetStringSlice("include"),
+		OneFileSystem:    viper.GetBool("one-file-system"),
+		ChangeRetries:    viper.GetInt("change-retries"),
+	}
+
+	var err error
+	if size := viper.GetString("max-size"); size != "" {
+		o.MaxSize, err = bytesize.Parse(size)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid max-size")
+		}
+	}
+	if size := viper.GetString("min-size"); size != "" {
+		o.MinSize, err = bytesize.Parse(size)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid min-size")
+		}
+	}
+	o.NewerThan, err = parseTime(viper.GetString("newer-than"))
 	if err != nil {
-		return errors.Wrap(err, "failed to initialize database")
+		return nil, errors.Wrap(err, "invalid newer-than")
+	}
+	return o, nil
+}
+
+func getHooks() (*hooks.Config, error) {
+	config := &hooks.Config{}
+	err := viper.UnmarshalKey("hooks", config, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		hooks.DecodeHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+	)))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid hooks config")
 	}
+	return config, nil
+}
 
+func sourceDecodeHook(from, to reflect.Type, data any) (any, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(backup.Source{}) {
+		return backup.Source{Dir: data.(string)}, nil
+	}
+	return data, nil
+}
+
+// getSources returns the directories to back up from the dirs option, falling
+// back to dir if it is not set.
+func getSources() ([]backup.Source, error) {
+	sources := []backup.Source{}
+	err := viper.UnmarshalKey("dirs", &sources, viper.DecodeHook(sourceDecodeHook))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid dirs")
+	}
+	if len(sources) == 0 {
+		sources = append(sources, backup.Source{Dir: viper.GetString("dir")})
+	}
+
+	for i, source := range sources {
+		if source.Dir == "" {
+			return nil, errors.Errorf("dirs[%d] has no path", i)
+		}
+		dir, err := filepath.Abs(os.ExpandEnv(source.Dir))
+		if err != nil {
+			return nil, err
+		}
+		sources[i].Dir = dir
+	}
+	err = backup.CheckSources(sources)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid dirs")
+	}
+	return sources, nil
+}
+
+func sourceDirs(sources []backup.Source) []string {
+	dirs := make([]string, len(sources))
+	for i, source := range sources {
+		dirs[i] = source.Dir
+	}
+	return dirs
+}
+
+func hookEnv(sources []backup.Source, start time.Time) []string {
+	return []string{
+		"BACKUP_DIR=" + sources[0].Dir,
+		"BACKUP_DIRS=" + strings.Join(sourceDirs(sources), string(os.PathListSeparator)),
+		"BACKUP_START=" + start.Format(time.RFC3339),
+	}
+}
+
+// dryRunBackup prints the files a backup would upload without writing to the
+// backends or the database.
+func dryRunBackup() error {
+	db, err := database.OpenReadOnly(databasePath())
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize database")
+	}
 	defer db.Close()
 
-	backends, err := getBackends()
+	sources, err := getSources()
 	if err != nil {
 		return err
 	}
 
+	backends, release, err := getBackupBackends(db, true)
+	if err != nil {
+		return err
+	}
+	defer release()
 	if len(backends) == 0 {
 		return fmt.Errorf("no backends set")
 	}
+	for _, b := range backends {
+		defer closeBackend(b)
+	}
+
+	options, err := getBackupOptions()
+	if err != nil {
+		return err
+	}
+	options.Backends = backends
+	options.DryRun = true
+	options.Output = os.Stdout
+	options.Progress = progressFunc()
+	if jsonOutput {
+		options.Output = io.Discard
+	}
+
+	report, err := backup.Backup(db, sources, options)
+	finishProgress(report)
+	if err != nil || jsonOutput {
+		return err
+	}
+
+	fmt.Printf("\n%d files scanned, %d unchanged\n", report.Scanned, report.Unchanged)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tFILES\tSIZE")
+	for _, b := range backends {
+		br := report.Backends[b.URI()]
+		fmt.Fprintf(w, "%s\t%d\t%s\n", b.URI(), br.Uploaded, bytesize.Format(br.BytesWritten))
+	}
+	return w.Flush()
+}
+
+func backupDB(db *database.DB) (report *backup.Report, err error) {
+	sources, err := getSources()
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Staring backup", "directories", sourceDirs(sources))
+
+	backends, release, err := getBackupBackends(db, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends set")
+	}
 
 	for _, b := range backends {
 		if b, ok := b.(backend.Closer); ok {
 			defer b.Close()
 		}
 	}
-	return backup.Backup(db, dir, &backup.Options{
-		Ignore:   viper.GetStringSlice("ignore"),
-		Backends: backends,
-	})
+
+	// everything that can fail before the backup starts is checked before the
+	// hooks run so they don't leave anything behind without a backup
+	options, err := getBackupOptions()
+	if err != nil {
+		return nil, err
+	}
+	hookConfig, err := getHooks()
+	if err != nil {
+		return nil, err
+	}
+	removeSnapshots, err := createSnapshots(sources)
+	if err != nil {
+		return nil, err
+	}
+	defer removeSnapshots()
+
+	env := hookEnv(sources, time.Now())
+	defer func() {
+		postEnv := append(env, "BACKUP_RESULT=success")
+		if err != nil {
+			postEnv = append(env, "BACKUP_RESULT=failure", "BACKUP_ERROR="+err.Error())
+		}
+		if report != nil {
+			postEnv = append(postEnv,
+				fmt.Sprintf("BACKUP_UPLOADED=%d", report.Uploaded()),
+				fmt.Sprintf("BACKUP_FAILED=%d", report.Failed()),
+			)
+		}
+		postErr := hookConfig.RunPost(postEnv)
+		if err == nil {
+			err = postErr
+		} else if postErr != nil {
+			slog.Error("post hook failed", "err", postErr)
+		}
+	}()
+
+	err = hookConfig.RunPre(env)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range hookConfig.Streams {
+		err = runStreamHook(hookConfig, h, env, backends)
+		if err != nil {
+			if !h.ContinueOnError {
+				return nil, err
+			}
+			slog.Warn("stream hook failed, continuing", "err", err)
+		}
+	}
+
+	options.Backends = backends
+	options.Progress = progressFunc()
+	report, err = backup.Backup(db, sources, options)
+	finishProgress(report)
+
+	if host := viper.GetString("host"); host != "" {
+		writeManifests(backends, &hosts.Manifest{
+			Host:       host,
+			Tags:       viper.GetStringSlice("tags"),
+			Dirs:       sourceDirs(sources),
+			LastBackup: report.Start,
+		})
+	}
+
+	saveStates(db, backends, report)
+
+	for _, b := range backends {
+		if _, ok := b.(*queue.Backend); ok {
+			report.Backends[b.URI()].Error = "backend unavailable, changes queued"
+		}
+		depth, err := db.QueueLength(b.URI())
+		if err != nil {
+			slog.Warn("failed to read queue length", "backend", b.URI(), "err", err)
+			continue
+		}
+		metrics.SetQueueDepth(b.URI(), depth)
+	}
+	return report, err
+}
+
+// recordBackendRuns saves the result of the run for each backend so status
+// can report on them individually.
+func recordBackendRuns(db *database.DB, report *backup.Report) {
+	for uri, br := range report.Backends {
+		run, err := db.GetBackendRun(uri)
+		if err != nil {
+			slog.Error("failed to read last backend run", "backend", uri, "err", err)
+			continue
+		}
+		run.LastRun = report.Start
+		run.RunID = report.RunID
+		run.Uploaded = br.Uploaded
+		run.FailedFiles = br.Failed
+		run.Error = br.Error
+		run.Failed = br.Failed > 0 || br.Error != ""
+		if !run.Failed {
+			run.LastSuccess = report.Start
+		}
+		err = db.SetBackendRun(uri, run)
+		if err != nil {
+			slog.Error("failed to save backend run", "backend", uri, "err", err)
+		}
+	}
+}
+
+// saveStates stores the files each reachable backend holds in it if they
+// changed in this run.
+func saveStates(db *database.DB, backends []backend.Backend, report *backup.Report) {
+	for _, b := range backends {
+		if _, ok := b.(*queue.Backend); ok {
+			continue
+		}
+		synced, err := db.Synced(b.URI())
+		if err != nil {
+			slog.Error("failed to read backend state", "backend", b.URI(), "err", err)
+			continue
+		}
+		br := report.Backends[b.URI()]
+		if br.Uploaded == 0 && br.Deleted == 0 && !synced.IsZero() {
+			continue
+		}
+		err = state.Save(db, b, report.Start)
+		if err != nil {
+			slog.Error("failed to save backend state", "backend", b.URI(), "err", err)
+		}
+	}
+}
+
+// writeManifests records the host in each backend that could be reached.
+func writeManifests(backends []backend.Backend, m *hosts.Manifest) {
+	for _, b := range backends {
+		if _, ok := b.(*queue.Backend); ok {
+			continue
+		}
+		err := hosts.Write(b, m)
+		if err != nil {
+			slog.Error("failed to write host manifest", "backend", b.URI(), "err", err)
+		}
+	}
+}
+
+// createSnapshots snapshots each source if a snapshot provider is configured
+// and returns a function that removes them.
+func createSnapshots(sources []backup.Source) (func(), error) {
+	config := &snapshot.Config{}
+	err := viper.UnmarshalKey("snapshot", config)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid snapshot config")
+	}
+	provider, err := snapshot.New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []*snapshot.Snapshot{}
+	remove := func() {
+		for _, s := range snapshots {
+			err := s.Remove()
+			if err != nil {
+				slog.Error("failed to remove snapshot", "dir", s.Dir, "err", err)
+			}
+		}
+	}
+	if provider == nil {
+		return remove, nil
+	}
+
+	name := fmt.Sprintf("backup-%d", time.Now().Unix())
+	for i, source := range sources {
+		s, err := provider.Create(source.Dir, fmt.Sprintf("%s-%d", name, i))
+		if err != nil {
+			remove()
+			return nil, errors.Wrapf(err, "failed to snapshot %s", source.Dir)
+		}
+		slog.Info("created snapshot", "dir", source.Dir, "snapshot", s.Dir)
+		snapshots = append(snapshots, s)
+		sources[i].Snapshot = s.Dir
+	}
+	return remove, nil
+}
+
+func runStreamHook(config *hooks.Config, h *hooks.Hook, env []string, backends []backend.Backend) error {
+	if h.Name == "" {
+		return errors.Errorf("stream hook %q has no name", h.Command)
+	}
+
+	t := time.Now()
+	f, err := config.RunStream(h, env)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	return backup.WriteStream(backends, h.Name, t, f)
+}
+
+// finishRun records the result of a run in the metrics and the database and
+// sends any notifications it triggers. db may be nil if it could not be
+// opened.
+func finishRun(db *database.DB, report *backup.Report, runErr error) {
+	metrics.RecordRun(report, runErr)
+
+	config := &notify.Config{}
+	err := viper.UnmarshalKey("notify", config)
+	if err != nil {
+		slog.Error("invalid notify config", "err", err)
+		return
+	}
+
+	state := &database.RunState{}
+	if db != nil {
+		state, err = db.GetRunState()
+		if err != nil {
+			slog.Error("failed to read last run", "err", err)
+		}
+	}
+
+	events := watchedRun.update(state, report, runErr, config.StaleAfter)
+
+	if db != nil {
+		err = db.SetRunState(state)
+		if err != nil {
+			slog.Error("failed to save run", "err", err)
+		}
+		recordBackendRuns(db, report)
+	}
+
+	notifiers := config.Notifiers()
+	for _, event := range events {
+		notify.Send(notifiers, notify.NewEvent(event, state, report))
+	}
 }
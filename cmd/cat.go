@@ -0,0 +1,73 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/spf13/cobra"
+)
+
+// catCmd represents the cat command
+var catCmd = &cobra.Command{
+	Use:   "cat <path>",
+	Short: "Write a backed up version of a file to stdout",
+	Long: `Writes a version of the file at path to stdout. Relative paths are
+resolved against the current directory.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backendName, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return err
+		}
+		atStr, err := cmd.Flags().GetString("at")
+		if err != nil {
+			return err
+		}
+		at, err := parseTime(atStr)
+		if err != nil {
+			return err
+		}
+
+		b, err := getBackend(backendName)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		p, err := backendPath(args[0])
+		if err != nil {
+			return err
+		}
+		f, err := b.Read(p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+
+		version, ok := backend.VersionAt(f, at)
+		if !ok {
+			return fmt.Errorf("no version of %s at %s", p, at.Format(time.RFC3339))
+		}
+
+		data, err := f.Data(version)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+
+		_, err = io.Copy(os.Stdout, data)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+
+	catCmd.Flags().String("backend", "", "the backend to read from, defaults to the first backend")
+	catCmd.Flags().String("at", "", "show the newest version at or before this time")
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/abibby/backup/bytesize"
+	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/lock"
+	"github.com/abibby/backup/replicate"
+	"github.com/abibby/backup/state"
+	"github.com/spf13/cobra"
+)
+
+// copyCmd represents the copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "Copy backed up versions from one backend to another",
+	Long: `Copies every version in the --from backend that the --to backend doesn't
+have. Backends can be given as they are written in the config or as a uri.
+
+The copied versions are recorded in the local database so the next backup
+doesn't upload them to --to again.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fromName, err := cmd.Flags().GetString("from")
+		if err != nil {
+			return err
+		}
+		toName, err := cmd.Flags().GetString("to")
+		if err != nil {
+			return err
+		}
+		sinceStr, err := cmd.Flags().GetString("since")
+		if err != nil {
+			return err
+		}
+		untilStr, err := cmd.Flags().GetString("until")
+		if err != nil {
+			return err
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		if fromName == "" || toName == "" {
+			return fmt.Errorf("--from and --to are required")
+		}
+		o := &replicate.Options{
+			DryRun: dryRun,
+			Output: os.Stdout,
+		}
+		o.Since, err = parseTime(sinceStr)
+		if err != nil {
+			return err
+		}
+		o.Until, err = parseTime(untilStr)
+		if err != nil {
+			return err
+		}
+
+		from, err := getBackend(fromName)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(from)
+		to, err := getBackend(toName)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(to)
+		if from.URI() == to.URI() {
+			return fmt.Errorf("--from and --to are the same backend")
+		}
+
+		var db *database.DB
+		if !dryRun {
+			held, err := lock.Acquire(to, "copy", false)
+			if err != nil {
+				return err
+			}
+			defer held.Release()
+
+			db, err = database.Open(databasePath())
+			if err != nil {
+				return fmt.Errorf("failed to initialize database: %w", err)
+			}
+			defer db.Close()
+
+			err = state.Sync(db, to)
+			if err != nil {
+				slog.Error("failed to sync local database with backend", "backend", to.URI(), "err", err)
+			}
+		}
+
+		result, err := replicate.Copy(db, from, to, o)
+		if db != nil && result != nil && result.Copied > 0 {
+			saveErr := state.Save(db, to, time.Now())
+			if saveErr != nil {
+				slog.Error("failed to save backend state", "backend", to.URI(), "err", saveErr)
+			}
+		}
+		if result != nil {
+			verb := "copied"
+			if dryRun {
+				verb = "would copy"
+			}
+			fmt.Fprintf(os.Stderr, "%s %d versions (%s), %d already present, %d failed\n",
+				verb, result.Copied, bytesize.Format(result.Bytes), result.Skipped, result.Failed)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(copyCmd)
+
+	copyCmd.Flags().String("from", "", "the backend to copy from")
+	copyCmd.Flags().String("to", "", "the backend to copy to")
+	copyCmd.Flags().String("since", "", "only copy versions from this time onwards")
+	copyCmd.Flags().String("until", "", "only copy versions up to this time")
+	copyCmd.Flags().Bool("dry-run", false, "list the versions that would be copied without copying them")
+}
@@ -0,0 +1,18 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Export, import and check the local database",
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/abibby/backup/database"
+	"github.com/spf13/cobra"
+)
+
+// dbCheckCmd represents the db check command
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the local database for inconsistencies",
+	Long: `Checks that the records in the local database are valid and agree with
+each other, e.g. that every file record belongs to a known backend and that
+its latest version is in the index. Each problem is printed on its own line.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.OpenReadOnly(databasePath())
+		if err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer db.Close()
+
+		problems, err := db.Check()
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems", len(problems))
+		}
+		return nil
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbCheckCmd)
+}
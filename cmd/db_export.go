@@ -0,0 +1,53 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abibby/backup/database"
+	"github.com/spf13/cobra"
+)
+
+// dbExportCmd represents the db export command
+var dbExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Write the local database as JSON lines",
+	Long: `Writes every record in the local database to file, or stdout if it isn't
+given, as one JSON object per line. The type field of each line says what it
+holds, e.g. schema, backend, file or index.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.OpenReadOnly(databasePath())
+		if err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer db.Close()
+
+		var w io.Writer = os.Stdout
+		if len(args) > 0 {
+			f, err := os.Create(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		err = db.Export(w)
+		if err != nil {
+			return err
+		}
+		if f, ok := w.(*os.File); ok && f != os.Stdout {
+			return f.Close()
+		}
+		return nil
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbExportCmd)
+}
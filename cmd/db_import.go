@@ -0,0 +1,73 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abibby/backup/database"
+	"github.com/spf13/cobra"
+)
+
+// dbImportCmd represents the db import command
+var dbImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Replace the local database with an export",
+	Long: `Loads a database written by db export from file, or stdin if it isn't
+given. The import is written to a new database that replaces the local one
+once it is complete. An existing database is only replaced with --force and
+never while another process has it open.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			return err
+		}
+
+		path := databasePath()
+		if _, err := os.Stat(path); err == nil && !force {
+			return fmt.Errorf("%s already exists, use --force to replace it", path)
+		}
+
+		var r io.Reader = os.Stdin
+		if len(args) > 0 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		tmp := path + ".import"
+		os.Remove(tmp)
+		db, err := database.Open(tmp)
+		if err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		err = db.Import(r)
+		closeErr := db.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		err = database.Replace(path, tmp)
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbImportCmd)
+
+	dbImportCmd.Flags().Bool("force", false, "replace the existing database")
+}
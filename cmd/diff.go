@@ -0,0 +1,328 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/backup"
+	"github.com/abibby/backup/database"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+)
+
+// maxDiffSize is the largest file that will have its content diffed.
+const maxDiffSize = 1 << 20
+
+type snapshotFile struct {
+	file    backend.File
+	version time.Time
+}
+
+type change struct {
+	status string
+	path   string
+	from   *snapshotFile
+	to     *snapshotFile
+}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [path]",
+	Short: "Show the files that changed between two points in time",
+	Long: `Compares the backed up files below path at --from with the files at --to
+and lists the ones that were added (A), removed (D) or modified (M), followed
+by a content diff of the modified text files.
+
+Files deleted from disk stay in the backends, removed files are found using
+the deletions recorded in the local database's index. With --live the files
+at --from are compared with the files on disk instead.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backendName, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return err
+		}
+		fromStr, err := cmd.Flags().GetString("from")
+		if err != nil {
+			return err
+		}
+		toStr, err := cmd.Flags().GetString("to")
+		if err != nil {
+			return err
+		}
+		live, err := cmd.Flags().GetBool("live")
+		if err != nil {
+			return err
+		}
+		nameOnly, err := cmd.Flags().GetBool("name-only")
+		if err != nil {
+			return err
+		}
+
+		if live && toStr != "" {
+			return fmt.Errorf("--to can't be used with --live")
+		}
+		if !live && fromStr == "" {
+			return fmt.Errorf("--from is required unless --live is set")
+		}
+		from, err := parseTime(fromStr)
+		if err != nil {
+			return err
+		}
+		to, err := parseTime(toStr)
+		if err != nil {
+			return err
+		}
+
+		b, err := getBackend(backendName)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		p := "/"
+		if len(args) > 0 {
+			p, err = backendPath(args[0])
+			if err != nil {
+				return err
+			}
+		}
+
+		files, err := backendFiles(b, p)
+		if err != nil {
+			return err
+		}
+		index, err := loadIndex(b.URI())
+		if err != nil {
+			return err
+		}
+		before := snapshotAt(files, index, from)
+
+		var changes []*change
+		if live {
+			changes, err = liveChanges(before, p)
+			if err != nil {
+				return err
+			}
+		} else {
+			changes = snapshotChanges(before, snapshotAt(files, index, to))
+		}
+
+		for _, c := range changes {
+			fmt.Printf("%s\t%s\n", c.status, c.path)
+		}
+		if nameOnly {
+			return nil
+		}
+		for _, c := range changes {
+			if c.status != "M" {
+				continue
+			}
+			err = printContentDiff(c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().String("backend", "", "the backend to read from, defaults to the first backend")
+	diffCmd.Flags().String("from", "", "the time to compare from")
+	diffCmd.Flags().String("to", "", "the time to compare to, defaults to the latest version")
+	diffCmd.Flags().Bool("live", false, "compare the files at --from with the files on disk")
+	diffCmd.Flags().Bool("name-only", false, "only list the changed files")
+}
+
+// backendFiles returns every file below p in b. p may also be a single file.
+func backendFiles(b backend.Backend, p string) (map[string]backend.File, error) {
+	files := map[string]backend.File{}
+	f, err := b.Read(p)
+	if err == nil {
+		files[p] = f
+		return files, nil
+	}
+
+	err = backend.Walk(b, p, func(p string, f backend.File) error {
+		files[p] = f
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not backed up", p)
+	} else if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// loadIndex returns the index entries of the backend with the given uri.
+func loadIndex(uri string) (map[string]*database.IndexEntry, error) {
+	db, err := database.OpenReadOnly(databasePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	index := map[string]*database.IndexEntry{}
+	err = db.Index(uri, func(p string, e *database.IndexEntry) error {
+		index[p] = e
+		return nil
+	})
+	return index, err
+}
+
+// snapshotAt returns the version of each file at t, leaving out files that
+// had been deleted by then.
+func snapshotAt(files map[string]backend.File, index map[string]*database.IndexEntry, t time.Time) map[string]*snapshotFile {
+	snapshot := map[string]*snapshotFile{}
+	for p, f := range files {
+		version, ok := backend.VersionAt(f, t)
+		if !ok {
+			continue
+		}
+		if e, ok := index[p]; ok && e.DeletedBetween(version, t) {
+			continue
+		}
+		snapshot[p] = &snapshotFile{file: f, version: version}
+	}
+	return snapshot
+}
+
+func snapshotChanges(before, after map[string]*snapshotFile) []*change {
+	changes := []*change{}
+	for p, from := range before {
+		to, ok := after[p]
+		if !ok {
+			changes = append(changes, &change{status: "D", path: p, from: from})
+		} else if !to.version.Equal(from.version) {
+			changes = append(changes, &change{status: "M", path: p, from: from, to: to})
+		}
+	}
+	for p, to := range after {
+		if _, ok := before[p]; !ok {
+			changes = append(changes, &change{status: "A", path: p, to: to})
+		}
+	}
+	sortChanges(changes)
+	return changes
+}
+
+// liveChanges compares before with the files on disk below p that would be
+// backed up.
+func liveChanges(before map[string]*snapshotFile, p string) ([]*change, error) {
+	sources, err := getSources()
+	if err != nil {
+		return nil, err
+	}
+	options, err := getBackupOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []*change{}
+	seen := map[string]bool{}
+	err = backup.Files(sources, options, func(f backup.File) {
+		if p != "/" && f.Path != p && !strings.HasPrefix(f.Path, p+"/") {
+			return
+		}
+		seen[f.Path] = true
+		from, ok := before[f.Path]
+		if !ok {
+			changes = append(changes, &change{status: "A", path: f.Path})
+		} else if f.Modified.Unix() != from.version.Unix() {
+			changes = append(changes, &change{status: "M", path: f.Path, from: from})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for p, from := range before {
+		if !seen[p] {
+			changes = append(changes, &change{status: "D", path: p, from: from})
+		}
+	}
+	sortChanges(changes)
+	return changes, nil
+}
+
+func sortChanges(changes []*change) {
+	slices.SortFunc(changes, func(a, b *change) int {
+		return strings.Compare(a.path, b.path)
+	})
+}
+
+// printContentDiff prints a unified diff of a modified file. A nil to is read
+// from disk.
+func printContentDiff(c *change) error {
+	fromName := fmt.Sprintf("%s@%s", c.path, c.from.version.Local().Format(time.DateTime))
+	fromText, ok, err := readText(c.from.file.Data(c.from.version))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("\nBinary or large files %s differ\n", c.path)
+		return nil
+	}
+
+	toName := c.path
+	var toText string
+	if c.to != nil {
+		toName = fmt.Sprintf("%s@%s", c.path, c.to.version.Local().Format(time.DateTime))
+		toText, ok, err = readText(c.to.file.Data(c.to.version))
+	} else {
+		toText, ok, err = readText(os.Open(c.path))
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("\nBinary or large files %s differ\n", c.path)
+		return nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromText),
+		B:        difflib.SplitLines(toText),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	if diff != "" {
+		fmt.Printf("\n%s", diff)
+	}
+	return nil
+}
+
+// readText reads all of r. ok is false if it is too large or isn't text.
+func readText[R io.ReadCloser](r R, err error) (string, bool, error) {
+	if err != nil {
+		return "", false, err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(io.LimitReader(r, maxDiffSize+1))
+	if err != nil {
+		return "", false, err
+	}
+	if len(b) > maxDiffSize || bytes.IndexByte(b, 0) != -1 {
+		return "", false, nil
+	}
+	return string(b), true, nil
+}
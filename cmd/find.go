@@ -0,0 +1,130 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abibby/backup/database"
+	"github.com/gobwas/glob"
+	"github.com/spf13/cobra"
+)
+
+// findCmd represents the find command
+var findCmd = &cobra.Command{
+	Use:   "find <pattern>",
+	Short: "Search every path that has been backed up",
+	Long: `Searches the index in the local database for backed up paths, including
+files that have since been deleted, and prints the periods they existed for.
+
+Patterns containing a / are matched against the full path, other patterns are
+matched against the file name. Patterns without wildcards match any name
+containing them. The index is updated by backup and can be rebuilt from the
+backends with reconcile.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backendName, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return err
+		}
+
+		match, err := findMatcher(args[0])
+		if err != nil {
+			return err
+		}
+
+		db, err := database.OpenReadOnly(databasePath())
+		if err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer db.Close()
+
+		uris, err := db.IndexedBackends()
+		if err != nil {
+			return err
+		}
+		if len(uris) == 0 {
+			return fmt.Errorf("the index is empty, run backup or reconcile to build it")
+		}
+		if backendName != "" {
+			uri, err := db.GetBackendURI(backendName)
+			if err != nil {
+				return err
+			}
+			if uri == "" {
+				uri = os.ExpandEnv(backendName)
+			}
+			uris = []string{uri}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if len(uris) > 1 {
+			fmt.Fprint(w, "BACKEND\t")
+		}
+		fmt.Fprintln(w, "PATH\tVERSIONS\tEXISTED")
+		for _, uri := range uris {
+			err = db.Index(uri, func(p string, e *database.IndexEntry) error {
+				if !match(p) {
+					return nil
+				}
+				if len(uris) > 1 {
+					fmt.Fprintf(w, "%s\t", uri)
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\n", p, len(e.Versions), formatRanges(e.Ranges()))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(findCmd)
+
+	findCmd.Flags().String("backend", "", "only search the index of this backend")
+}
+
+func findMatcher(pattern string) (func(p string) bool, error) {
+	fullPath := strings.Contains(pattern, "/")
+	target := func(p string) string {
+		if fullPath {
+			return p
+		}
+		return path.Base(p)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[{") {
+		return func(p string) bool {
+			return strings.Contains(target(p), pattern)
+		}, nil
+	}
+
+	g, err := glob.Compile(pattern, '/')
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return func(p string) bool {
+		return g.Match(target(p))
+	}, nil
+}
+
+func formatRanges(ranges []database.Range) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		to := "present"
+		if !r.To.IsZero() {
+			to = "deleted " + r.To.Local().Format(time.DateTime)
+		}
+		parts[i] = r.From.Local().Format(time.DateTime) + " - " + to
+	}
+	return strings.Join(parts, ", ")
+}
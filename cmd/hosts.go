@@ -0,0 +1,68 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/hosts"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// hostsCmd represents the hosts command
+var hostsCmd = &cobra.Command{
+	Use:   "hosts",
+	Short: "List the hosts that back up to each backend",
+	Long: `Lists the hosts that share each backend. Hosts are set with the host
+option, the files of another host can be read by passing --host to commands
+like ls, cat and diff.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag, err := cmd.Flags().GetString("tag")
+		if err != nil {
+			return err
+		}
+
+		backends, err := getBackends()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "BACKEND\tHOST\tTAGS\tLAST BACKUP\tDIRS")
+		for _, b := range backends {
+			defer closeBackend(b)
+			repo := b.URI()
+			if host := viper.GetString("host"); host != "" {
+				repo = strings.TrimSuffix(repo, backend.NamespaceDir(host))
+			}
+			manifests, err := hosts.List(b)
+			if err != nil {
+				return err
+			}
+			for _, m := range manifests {
+				if tag != "" && !slices.Contains(m.Tags, tag) {
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					repo, m.Host, strings.Join(m.Tags, ","),
+					m.LastBackup.Local().Format(time.DateTime), strings.Join(m.Dirs, ","))
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hostsCmd)
+
+	hostsCmd.Flags().String("tag", "", "only list hosts with this tag")
+}
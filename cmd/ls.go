@@ -0,0 +1,110 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/bytesize"
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Short: "List a directory in a backend",
+	Long: `Lists the files in a backed up directory with the size and time of their
+latest version. With --at the newest version at or before that time is shown
+and files and directories that didn't exist yet are hidden.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backendName, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return err
+		}
+		atStr, err := cmd.Flags().GetString("at")
+		if err != nil {
+			return err
+		}
+		at, err := parseTime(atStr)
+		if err != nil {
+			return err
+		}
+
+		b, err := getBackend(backendName)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		p := "/"
+		if len(args) > 0 {
+			p, err = backendPath(args[0])
+			if err != nil {
+				return err
+			}
+		}
+		files, err := b.List(p)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", p, err)
+		}
+		slices.SortFunc(files, func(a, b backend.File) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, f := range files {
+			if f.IsDir() {
+				if !at.IsZero() {
+					ok, err := existedAt(b, path.Join(p, f.Name()), at)
+					if err != nil {
+						return fmt.Errorf("failed to list %s: %w", path.Join(p, f.Name()), err)
+					}
+					if !ok {
+						continue
+					}
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", "-", "-", f.Name()+"/")
+				continue
+			}
+			version, ok := backend.VersionAt(f, at)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", bytesize.Format(f.Size(version)), version.Local().Format(time.DateTime), f.Name())
+		}
+		return w.Flush()
+	},
+}
+
+var errFound = errors.New("found")
+
+// existedAt returns true if any file below dir has a version at or before at.
+func existedAt(b backend.Backend, dir string, at time.Time) (bool, error) {
+	err := backend.Walk(b, dir, func(p string, f backend.File) error {
+		if _, ok := backend.VersionAt(f, at); ok {
+			return errFound
+		}
+		return nil
+	})
+	if errors.Is(err, errFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().String("backend", "", "the backend to list, defaults to the first backend")
+	lsCmd.Flags().String("at", "", "show the directory as it was at this time")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abibby/backup/backup"
+	"github.com/abibby/backup/bytesize"
+)
+
+var (
+	jsonOutput  bool
+	progressBar bool
+)
+
+type progressEvent struct {
+	Type             string  `json:"type"`
+	Scanning         bool    `json:"scanning"`
+	Scanned          int64   `json:"scanned"`
+	FilesTotal       int64   `json:"files_total"`
+	FilesDone        int64   `json:"files_done"`
+	BytesTotal       int64   `json:"bytes_total"`
+	BytesDone        int64   `json:"bytes_done"`
+	ElapsedSeconds   float64 `json:"elapsed_seconds"`
+	RemainingSeconds float64 `json:"remaining_seconds"`
+}
+
+type reportEvent struct {
+	Type   string         `json:"type"`
+	Report *backup.Report `json:"report"`
+}
+
+// progressFunc returns the progress callback for the --json and --progress
+// flags, or nil to log progress.
+func progressFunc() func(backup.Progress) {
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		return func(p backup.Progress) {
+			_ = enc.Encode(&progressEvent{
+				Type:             "progress",
+				Scanning:         p.Scanning,
+				Scanned:          p.Scanned,
+				FilesTotal:       p.FilesTotal,
+				FilesDone:        p.FilesDone,
+				BytesTotal:       p.BytesTotal,
+				BytesDone:        p.BytesDone,
+				ElapsedSeconds:   p.Elapsed.Seconds(),
+				RemainingSeconds: p.Remaining.Seconds(),
+			})
+		}
+	}
+	if progressBar && isTerminal(os.Stderr) {
+		return drawProgressBar
+	}
+	return nil
+}
+
+// finishProgress ends the progress output, writing the final report when
+// --json is set.
+func finishProgress(report *backup.Report) {
+	if jsonOutput && report != nil {
+		_ = json.NewEncoder(os.Stdout).Encode(&reportEvent{
+			Type:   "report",
+			Report: report,
+		})
+	} else if progressBar && isTerminal(os.Stderr) {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+const barWidth = 30
+
+func drawProgressBar(p backup.Progress) {
+	fraction := 0.0
+	if p.BytesTotal > 0 {
+		fraction = float64(p.BytesDone) / float64(p.BytesTotal)
+	} else if p.FilesTotal > 0 {
+		fraction = float64(p.FilesDone) / float64(p.FilesTotal)
+	}
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	eta := "ETA --"
+	if p.Remaining > 0 {
+		eta = "ETA " + p.Remaining.Truncate(time.Second).String()
+	}
+	scanning := ""
+	if p.Scanning {
+		scanning = " (scanning)"
+	}
+
+	fmt.Fprintf(os.Stderr, "\r\033[K[%s] %3.0f%%  %d/%d files  %s/%s  %s%s",
+		bar,
+		fraction*100,
+		p.FilesDone,
+		p.FilesTotal,
+		bytesize.Format(p.BytesDone),
+		bytesize.Format(p.BytesTotal),
+		eta,
+		scanning,
+	)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -16,37 +16,80 @@ limitations under the License.
 package cmd
 
 import (
-	"github.com/abibby/backup/backend"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/lock"
 	"github.com/abibby/backup/reconcile"
+	"github.com/abibby/backup/state"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // reconcileCmd represents the reconcile command
 var reconcileCmd = &cobra.Command{
 	Use:   "reconcile",
 	Short: "Update the local database to match the remote storage backend",
-	Long:  ``,
+	Long: `Compares the files recorded in the local database with the files in each
+backend and updates the database to match. Each difference is listed as
+missing-locally, missing-remotely or newer-remotely.
+
+The backend is compared in batches and the progress is saved after each one,
+if reconcile is interrupted the next run continues where it stopped.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		db, err := database.Open(viper.GetString("database"))
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+		batchSize, err := cmd.Flags().GetInt("batch-size")
+		if err != nil {
+			return err
+		}
+		restart, err := cmd.Flags().GetBool("restart")
+		if err != nil {
+			return err
+		}
+
+		db, err := database.Open(databasePath())
 		if err != nil {
 			return errors.Wrap(err, "failed to initialize database")
 		}
+		defer db.Close()
 
 		backends, err := getBackends()
 		if err != nil {
 			return err
 		}
 		for _, b := range backends {
-			if b, ok := b.(backend.Closer); ok {
-				defer b.Close()
+			defer closeBackend(b)
+			held, err := lock.Acquire(b, "reconcile", !dryRun)
+			if err != nil {
+				return err
+			}
+			result, err := reconcile.Reconcile(db, b, &reconcile.Options{
+				DryRun:    dryRun,
+				BatchSize: batchSize,
+				Restart:   restart,
+				Difference: func(d *reconcile.Difference) {
+					fmt.Printf("%s\t%s\t%s\t%s\n", d.Kind, d.Path, formatTime(&d.Local), formatTime(&d.Remote))
+				},
+			})
+			if result != nil {
+				fmt.Fprintf(os.Stderr, "%s: checked %d files, %d missing locally, %d missing remotely, %d newer remotely\n",
+					b.URI(), result.Checked, result.MissingLocally, result.MissingRemotely, result.NewerRemotely)
 			}
-			err = reconcile.Reconcile(db, b)
+			if err == nil && !dryRun {
+				err = state.Save(db, b, time.Now())
+			}
+			releaseErr := held.Release()
 			if err != nil {
 				return err
 			}
+			if releaseErr != nil {
+				return releaseErr
+			}
 		}
 		return nil
 	},
@@ -55,13 +98,7 @@ var reconcileCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(reconcileCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// reconcileCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// reconcileCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	reconcileCmd.Flags().Bool("dry-run", false, "list the differences without updating the database")
+	reconcileCmd.Flags().Int("batch-size", reconcile.DefaultBatchSize, "the number of files compared in each database transaction")
+	reconcileCmd.Flags().Bool("restart", false, "start over instead of resuming an interrupted reconcile")
 }
@@ -49,6 +49,10 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/backup/config.yml)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "V", false, "")
+	rootCmd.PersistentFlags().String("host", "", "store and read files under this host in shared repositories")
+	viper.BindPFlag("host", rootCmd.PersistentFlags().Lookup("host"))
+	rootCmd.PersistentFlags().Bool("stateless", false, "keep the local database in the cache directory and restore it from the backends")
+	viper.BindPFlag("stateless", rootCmd.PersistentFlags().Lookup("stateless"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -74,7 +78,7 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 
 	level := slog.LevelInfo
@@ -0,0 +1,238 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/bytesize"
+	"github.com/abibby/backup/database"
+	"github.com/spf13/cobra"
+)
+
+// maxFailedFiles is the number of failed files listed for each backend in the
+// table output.
+const maxFailedFiles = 10
+
+type backendStatus struct {
+	Backend string `json:"backend"`
+	URI     string `json:"uri"`
+	// Error is set if the backend couldn't be loaded or listed.
+	Error        string               `json:"error,omitempty"`
+	LastRun      *database.BackendRun `json:"last_run,omitempty"`
+	SinceSuccess *duration            `json:"since_success,omitempty"`
+	Files        int                  `json:"files"`
+	Versions     int                  `json:"versions"`
+	Size         int64                `json:"size"`
+	Oldest       *time.Time           `json:"oldest,omitempty"`
+	Newest       *time.Time           `json:"newest,omitempty"`
+	Pending      int                  `json:"pending"`
+	Failed       map[string]string    `json:"failed"`
+}
+
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Seconds())
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the health of each backend",
+	Long: `Shows the last run, the files stored and any pending or failed uploads for
+each backend. By default the backends are listed to count the files they
+store, use --local to only read the local database.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return err
+		}
+		local, err := cmd.Flags().GetBool("local")
+		if err != nil {
+			return err
+		}
+
+		db, err := database.OpenReadOnly(databasePath())
+		if err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer db.Close()
+
+		loaded, err := loadBackends()
+		if err != nil {
+			return err
+		}
+
+		statuses := make([]*backendStatus, 0, len(loaded))
+		for _, l := range loaded {
+			s, err := getStatus(db, l, local)
+			if err != nil {
+				return err
+			}
+			statuses = append(statuses, s)
+		}
+
+		if asJSON {
+			e := json.NewEncoder(os.Stdout)
+			e.SetIndent("", "    ")
+			return e.Encode(statuses)
+		}
+		return printStatus(statuses, local)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().Bool("json", false, "output the status as JSON")
+	statusCmd.Flags().Bool("local", false, "only read the local database, don't list the backends")
+}
+
+func getStatus(db *database.DB, l *loadedBackend, local bool) (*backendStatus, error) {
+	s := &backendStatus{
+		Backend: l.Key,
+		Failed:  map[string]string{},
+	}
+	if l.Err != nil {
+		s.Error = l.Err.Error()
+		uri, err := db.GetBackendURI(l.Key)
+		if err != nil {
+			return nil, err
+		}
+		s.URI = uri
+	} else {
+		s.URI = l.Backend.URI()
+		defer closeBackend(l.Backend)
+	}
+	if s.URI == "" {
+		return s, nil
+	}
+
+	run, err := db.GetBackendRun(s.URI)
+	if err != nil {
+		return nil, err
+	}
+	if !run.LastRun.IsZero() {
+		s.LastRun = run
+	}
+	if !run.LastSuccess.IsZero() {
+		d := duration(time.Since(run.LastSuccess).Truncate(time.Second))
+		s.SinceSuccess = &d
+	}
+
+	s.Pending, err = db.QueueLength(s.URI)
+	if err != nil {
+		return nil, err
+	}
+	s.Failed, err = db.Failed(s.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	if local || l.Err != nil {
+		s.Files, err = db.FileCount(s.URI)
+		return s, err
+	}
+
+	err = backend.Walk(l.Backend, "/", func(p string, f backend.File) error {
+		versions := f.Versions()
+		if len(versions) == 0 {
+			return nil
+		}
+		s.Files++
+		s.Versions += len(versions)
+		for _, v := range versions {
+			s.Size += f.Size(v)
+		}
+		oldest, newest := versions[0], versions[len(versions)-1]
+		if s.Oldest == nil || oldest.Before(*s.Oldest) {
+			s.Oldest = &oldest
+		}
+		if s.Newest == nil || newest.After(*s.Newest) {
+			s.Newest = &newest
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		s.Error = fmt.Sprintf("failed to list backend: %v", err)
+	}
+	return s, nil
+}
+
+func printStatus(statuses []*backendStatus, local bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if local {
+		fmt.Fprintln(w, "BACKEND\tLAST RUN\tRESULT\tSINCE SUCCESS\tFILES\tPENDING\tFAILED")
+	} else {
+		fmt.Fprintln(w, "BACKEND\tLAST RUN\tRESULT\tSINCE SUCCESS\tFILES\tVERSIONS\tSIZE\tOLDEST\tNEWEST\tPENDING\tFAILED")
+	}
+	for _, s := range statuses {
+		lastRun, result, since := "never", "-", "never"
+		if s.LastRun != nil {
+			lastRun = formatTime(&s.LastRun.LastRun)
+			result = "success"
+			if s.LastRun.Failed {
+				result = "failed"
+			}
+		}
+		if s.SinceSuccess != nil {
+			since = time.Duration(*s.SinceSuccess).String()
+		}
+		if local {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\n",
+				s.Backend, lastRun, result, since, s.Files, s.Pending, len(s.Failed))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\n",
+				s.Backend, lastRun, result, since, s.Files, s.Versions, bytesize.Format(s.Size),
+				formatTime(s.Oldest), formatTime(s.Newest), s.Pending, len(s.Failed))
+		}
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Printf("\n%s: %s\n", s.Backend, s.Error)
+		}
+		if s.LastRun != nil && s.LastRun.Error != "" {
+			fmt.Printf("\n%s: last run: %s\n", s.Backend, s.LastRun.Error)
+		}
+		if len(s.Failed) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s: %d failed files\n", s.Backend, len(s.Failed))
+		paths := make([]string, 0, len(s.Failed))
+		for p := range s.Failed {
+			paths = append(paths, p)
+		}
+		slices.Sort(paths)
+		for i, p := range paths {
+			if i == maxFailedFiles {
+				fmt.Printf("  ... and %d more\n", len(paths)-maxFailedFiles)
+				break
+			}
+			fmt.Printf("  %s: %s\n", p, s.Failed[p])
+		}
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/abibby/backup/backup"
+	"github.com/spf13/cobra"
+)
+
+// streamCmd represents the stream command
+var streamCmd = &cobra.Command{
+	Use:   "stream",
+	Short: "Back up stdin as a virtual file",
+	Long: `Reads stdin and writes it as a new version of the file given by --name to every
+backend, e.g.
+
+  pg_dump mydb | backup stream --name db/dump.sql
+
+The file is stored at /db/dump.sql. Paths given to cat, ls and versions are
+resolved against the current directory, so read it back with its absolute
+path:
+
+  backup cat /db/dump.sql`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return fmt.Errorf("--name is required")
+		}
+
+		backends, err := getBackends()
+		if err != nil {
+			return err
+		}
+		if len(backends) == 0 {
+			return fmt.Errorf("no backends set")
+		}
+		for _, b := range backends {
+			defer closeBackend(b)
+		}
+
+		return backup.WriteStream(backends, name, time.Now(), os.Stdin)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(streamCmd)
+
+	streamCmd.Flags().String("name", "", "the path to store the stream at")
+}
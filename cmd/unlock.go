@@ -0,0 +1,88 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/lock"
+	"github.com/spf13/cobra"
+)
+
+// unlockCmd represents the unlock command
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "List and remove repository locks",
+	Long: `Lists the locks held in each backend. A lock is stale if it has expired or
+the process holding it is no longer running on this host. Use --stale to
+remove stale locks or --all to remove every lock.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backendName, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return err
+		}
+		stale, err := cmd.Flags().GetBool("stale")
+		if err != nil {
+			return err
+		}
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+
+		var backends []backend.Backend
+		if backendName != "" {
+			b, err := getBackend(backendName)
+			if err != nil {
+				return err
+			}
+			backends = []backend.Backend{b}
+		} else {
+			backends, err = getBackends()
+			if err != nil {
+				return err
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "BACKEND\tID\tMODE\tCOMMAND\tHOST\tPID\tCREATED\tEXPIRES\tSTATUS")
+		for _, b := range backends {
+			defer closeBackend(b)
+			locks, err := lock.List(b)
+			if err != nil {
+				return err
+			}
+			for _, l := range locks {
+				status := "held"
+				if l.Stale() {
+					status = "stale"
+				}
+				if all || (stale && l.Stale()) {
+					err = lock.Remove(b, l)
+					if err != nil {
+						return fmt.Errorf("failed to remove lock %s: %w", l.ID, err)
+					}
+					status = "removed"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+					b.URI(), l.ID, l.Mode(), l.Command, l.Host, l.PID,
+					l.Created.Local().Format(time.DateTime), l.Expires.Local().Format(time.DateTime), status)
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+
+	unlockCmd.Flags().String("backend", "", "only unlock this backend")
+	unlockCmd.Flags().Bool("stale", false, "remove stale locks")
+	unlockCmd.Flags().Bool("all", false, "remove every lock, including ones held by running processes")
+}
@@ -1,21 +1,265 @@
 package cmd
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/ratelimit"
 	"github.com/spf13/viper"
 )
 
+type loadedBackend struct {
+	// Key is the backend as it is written in the config, before environment
+	// variables are expanded.
+	Key     string
+	Backend backend.Backend
+	Err     error
+}
+
+// loadBackends tries to open every configured backend. A backend that fails
+// to load does not stop the others from loading.
+func loadBackends() ([]*loadedBackend, error) {
+	global, err := globalLimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	backends := []*loadedBackend{}
+	for _, key := range viper.GetStringSlice("backends") {
+		b, err := loadBackend(os.ExpandEnv(key), global)
+		backends = append(backends, &loadedBackend{
+			Key:     key,
+			Backend: b,
+			Err:     err,
+		})
+	}
+	return backends, nil
+}
+
+// getBackends returns every backend that could be loaded, logging the ones
+// that could not.
 func getBackends() ([]backend.Backend, error) {
-	backendUris := viper.GetStringSlice("backends")
+	loaded, err := loadBackends()
+	if err != nil {
+		return nil, err
+	}
+
 	backends := []backend.Backend{}
-	for _, uri := range backendUris {
-		b, err := backend.Load(os.ExpandEnv(uri))
+	for _, l := range loaded {
+		if l.Err != nil {
+			slog.Error("failed to load backend", "backend", l.Key, "err", l.Err)
+			continue
+		}
+		backends = append(backends, l.Backend)
+	}
+	return backends, nil
+}
+
+// getBackend loads a single backend. name can be a backend as it is written
+// in the config, its expanded form or any other backend uri. If name is empty
+// the first configured backend that loads is returned.
+func getBackend(name string) (backend.Backend, error) {
+	global, err := globalLimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, key := range viper.GetStringSlice("backends") {
+		uri := os.ExpandEnv(key)
+		if name != "" && name != key && name != uri {
+			continue
+		}
+		b, err := loadBackend(uri, global)
 		if err != nil {
+			slog.Error("failed to load backend", "backend", key, "err", err)
+			lastErr = err
+			continue
+		}
+		return b, nil
+	}
+
+	if name != "" {
+		return loadBackend(os.ExpandEnv(name), global)
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("no backends set")
+}
+
+func closeBackend(b backend.Backend) {
+	if c, ok := b.(backend.Closer); ok {
+		c.Close()
+	}
+}
+
+var timeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime parses a time given on the command line. It accepts RFC 3339,
+// dates with an optional local time, unix timestamps and durations which are
+// treated as that long ago.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range timeFormats {
+		t, err := time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// backendPath converts a path given on the command line into the absolute
+// path it is stored at in a backend. Relative paths are relative to the
+// current directory, as they are for any other command.
+func backendPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(abs), nil
+}
+
+// databasePath returns the path of the local database. In stateless mode the
+// database is a cache in the user's cache directory that is restored from the
+// state stored in the backends whenever it is missing.
+func databasePath() string {
+	if !viper.GetBool("stateless") {
+		return viper.GetString("database")
+	}
+	dir, err := os.UserCacheDir()
+	if err == nil {
+		dir = filepath.Join(dir, "backup")
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		slog.Warn("no cache directory for the stateless database, using the database option", "err", err)
+		return viper.GetString("database")
+	}
+	id := strings.Join(viper.GetStringSlice("backends"), "\n") + "\n" + viper.GetString("host")
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(dir, fmt.Sprintf("%x.bolt", sum[:8]))
+}
+
+// loadBackend opens the backend at uri. The bandwidth query parameter is
+// removed from the uri and used to throttle writes to that backend alongside
+// the global limiter. The host query parameter overrides the host the files
+// are stored under, an empty host uses the root of the backend.
+func loadBackend(uri string, global *ratelimit.Limiter) (backend.Backend, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	limiters := []*ratelimit.Limiter{}
+	if global != nil {
+		limiters = append(limiters, global)
+	}
+
+	query := u.Query()
+	if bandwidth := query.Get("bandwidth"); bandwidth != "" {
+		rate, err := ratelimit.ParseRate(bandwidth)
+		if err != nil {
+			return nil, err
+		}
+		if rate > 0 {
+			limiters = append(limiters, ratelimit.New(ratelimit.Fixed(rate)))
+		}
+		query.Del("bandwidth")
+		u.RawQuery = query.Encode()
+	}
+
+	host := viper.GetString("host")
+	if query.Has("host") {
+		host = query.Get("host")
+		query.Del("host")
+		u.RawQuery = query.Encode()
+	}
+
+	b, err := backend.Load(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		namespaced, err := backend.Namespace(b, host)
+		if err != nil {
+			closeBackend(b)
 			return nil, err
 		}
-		backends = append(backends, b)
+		b = namespaced
 	}
-	return backends, nil
+	return backend.Limit(b, limiters...), nil
+}
+
+type bandwidthWindow struct {
+	From  string `mapstructure:"from"`
+	To    string `mapstructure:"to"`
+	Limit string `mapstructure:"limit"`
+}
+
+// globalLimiter creates the limiter shared by every backend from the
+// bandwidth and bandwidth-schedule options. It returns nil when there is no
+// limit configured.
+func globalLimiter() (*ratelimit.Limiter, error) {
+	defaultRate, err := ratelimit.ParseRate(viper.GetString("bandwidth"))
+	if err != nil {
+		return nil, err
+	}
+
+	windows := []bandwidthWindow{}
+	err = viper.UnmarshalKey("bandwidth-schedule", &windows)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bandwidth-schedule: %w", err)
+	}
+
+	if defaultRate == 0 && len(windows) == 0 {
+		return nil, nil
+	}
+
+	schedule := &ratelimit.Schedule{
+		Default: defaultRate,
+	}
+	for _, w := range windows {
+		from, err := ratelimit.ParseTimeOfDay(w.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := ratelimit.ParseTimeOfDay(w.To)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := ratelimit.ParseRate(w.Limit)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Windows = append(schedule.Windows, ratelimit.Window{
+			From:  from,
+			To:    to,
+			Limit: limit,
+		})
+	}
+	return ratelimit.New(schedule.Rate), nil
 }
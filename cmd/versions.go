@@ -0,0 +1,56 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abibby/backup/bytesize"
+	"github.com/spf13/cobra"
+)
+
+// versionsCmd represents the versions command
+var versionsCmd = &cobra.Command{
+	Use:   "versions <path>",
+	Short: "List every backed up version of a file",
+	Long:  ``,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backendName, err := cmd.Flags().GetString("backend")
+		if err != nil {
+			return err
+		}
+
+		b, err := getBackend(backendName)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		p, err := backendPath(args[0])
+		if err != nil {
+			return err
+		}
+		f, err := b.Read(p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tUNIX\tSIZE")
+		for _, v := range f.Versions() {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", v.Local().Format(time.DateTime), v.Unix(), bytesize.Format(f.Size(v)))
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionsCmd)
+
+	versionsCmd.Flags().String("backend", "", "the backend to read from, defaults to the first backend")
+}
@@ -5,8 +5,14 @@ package cmd
 
 import (
 	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/abibby/backup/backup"
+	"github.com/abibby/backup/database"
+	"github.com/abibby/backup/metrics"
+	"github.com/abibby/backup/notify"
+	"github.com/abibby/backup/server"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -17,6 +23,21 @@ var watchCmd = &cobra.Command{
 	Short: "",
 	Long:  ``,
 	Run: func(cmd *cobra.Command, args []string) {
+		if addr := viper.GetString("watch.metrics"); addr != "" {
+			go func() {
+				slog.Info("serving metrics", "addr", addr)
+				err := server.ListenMetrics(addr)
+				if err != nil {
+					slog.Error("metrics server stopped", "err", err)
+				}
+			}()
+		}
+		watchedRun.load()
+		go func() {
+			for range time.Tick(staleCheckInterval) {
+				watchedRun.check()
+			}
+		}()
 		for {
 			frequency := viper.GetDuration("watch.frequency")
 			if frequency == 0 {
@@ -37,6 +58,78 @@ var watchCmd = &cobra.Command{
 	},
 }
 
+// staleCheckInterval is how often watch checks for a stale backup, both
+// between runs and while a run is hung.
+const staleCheckInterval = time.Minute
+
+// runMonitor keeps the state of the last run in memory so that watch can
+// check for a stale backup without opening the database while a backup holds
+// it.
+type runMonitor struct {
+	mtx   sync.Mutex
+	state *database.RunState
+}
+
+var watchedRun = &runMonitor{}
+
+// load reads the state of the last run from the database when watch starts.
+func (m *runMonitor) load() {
+	db, err := database.OpenReadOnly(databasePath())
+	if err != nil {
+		slog.Error("failed to open database", "err", err)
+		return
+	}
+	defer db.Close()
+	state, err := db.GetRunState()
+	if err != nil {
+		slog.Error("failed to read last run", "err", err)
+		return
+	}
+	metrics.SetLastSuccess(state.LastSuccess)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.state = state
+}
+
+// update records the result of a run with notify.Update. A stale
+// notification that check has already sent isn't sent again.
+func (m *runMonitor) update(state *database.RunState, report *backup.Report, runErr error, staleAfter time.Duration) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.state != nil && m.state.StaleNotified && m.state.LastSuccess.Equal(state.LastSuccess) {
+		state.StaleNotified = true
+	}
+	events := notify.Update(state, report, runErr, staleAfter)
+	saved := *state
+	m.state = &saved
+	return events
+}
+
+// check sends a stale notification if there hasn't been a successful run for
+// longer than notify.stale-after.
+func (m *runMonitor) check() {
+	config := &notify.Config{}
+	err := viper.UnmarshalKey("notify", config)
+	if err != nil {
+		slog.Error("invalid notify config", "err", err)
+		return
+	}
+
+	m.mtx.Lock()
+	if m.state == nil || !notify.Stale(m.state, time.Now(), config.StaleAfter) {
+		m.mtx.Unlock()
+		return
+	}
+	m.state.StaleNotified = true
+	state := *m.state
+	m.mtx.Unlock()
+
+	// a slow notifier must not hold up update at the end of a run
+	report := &backup.Report{Start: state.LastRun, Duration: state.Duration}
+	notify.Send(config.Notifiers(), notify.NewEvent(notify.EventStale, &state, report))
+}
+
 func init() {
 	rootCmd.AddCommand(watchCmd)
 
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// backendIDsBucket maps every uri a backend has had to its id.
+	backendIDsBucket = []byte("backend-ids")
+	// backendsBucket maps the id of each backend to its current uri.
+	backendsBucket = []byte("backends")
+	// repositoriesBucket maps the id of each backend to the identity of the
+	// files it stores, see backend.Identity.
+	repositoriesBucket = []byte("backend-repositories")
+)
+
+func newID() []byte {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return []byte(hex.EncodeToString(b))
+}
+
+// backendID returns the id of the backend with the given uri. If it doesn't
+// have one a new id is created when create is true, otherwise nil is
+// returned.
+func backendID(tx *bbolt.Tx, uri string, create bool) ([]byte, error) {
+	if ids := tx.Bucket(backendIDsBucket); ids != nil {
+		if id := ids.Get([]byte(uri)); id != nil {
+			return slices.Clone(id), nil
+		}
+	}
+	if !create {
+		return nil, nil
+	}
+
+	id := newID()
+	err := setBackendID(tx, uri, id)
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func setBackendID(tx *bbolt.Tx, uri string, id []byte) error {
+	ids, err := tx.CreateBucketIfNotExists(backendIDsBucket)
+	if err != nil {
+		return err
+	}
+	err = ids.Put([]byte(uri), id)
+	if err != nil {
+		return err
+	}
+	backends, err := tx.CreateBucketIfNotExists(backendsBucket)
+	if err != nil {
+		return err
+	}
+	return backends.Put(id, []byte(uri))
+}
+
+// backendURI returns the current uri of the backend with the given id.
+func backendURI(tx *bbolt.Tx, id []byte) string {
+	backends := tx.Bucket(backendsBucket)
+	if backends == nil {
+		return ""
+	}
+	return string(backends.Get(id))
+}
+
+// backendRepository returns the identity of the files stored in the backend
+// with the given id or "" if it isn't known.
+func backendRepository(tx *bbolt.Tx, id []byte) string {
+	repositories := tx.Bucket(repositoriesBucket)
+	if repositories == nil {
+		return ""
+	}
+	return string(repositories.Get(id))
+}
+
+func setBackendRepository(tx *bbolt.Tx, id []byte, repository string) error {
+	repositories, err := tx.CreateBucketIfNotExists(repositoriesBucket)
+	if err != nil {
+		return err
+	}
+	return repositories.Put(id, []byte(repository))
+}
+
+// nested returns the bucket for the backend with the given id inside the top
+// level bucket name, or nil if either doesn't exist.
+func nested(tx *bbolt.Tx, name, id []byte) *bbolt.Bucket {
+	if id == nil {
+		return nil
+	}
+	parent := tx.Bucket(name)
+	if parent == nil {
+		return nil
+	}
+	return parent.Bucket(id)
+}
+
+func createNested(tx *bbolt.Tx, name, id []byte) (*bbolt.Bucket, error) {
+	parent, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return parent.CreateBucketIfNotExists(id)
+}
+
+// BackendID returns the id the database stores the backend with the given uri
+// under, or "" if it has never been used.
+func (db *DB) BackendID(uri string) (string, error) {
+	var id []byte
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		id, err = backendID(tx, uri, false)
+		return err
+	})
+	return string(id), errors.Wrap(err, "failed to read database")
+}
+
+// viewURI calls fn in a read only transaction with the id of the backend with
+// the given uri. The id is nil if the backend hasn't been used before.
+func (db *DB) viewURI(uri string, fn func(tx *bbolt.Tx, id []byte) error) error {
+	return db.db.View(func(tx *bbolt.Tx) error {
+		id, err := backendID(tx, uri, false)
+		if err != nil {
+			return err
+		}
+		return fn(tx, id)
+	})
+}
+
+// updateURI calls fn in a writable transaction with the id of the backend with
+// the given uri, creating one if it hasn't been used before.
+func (db *DB) updateURI(uri string, fn func(tx *bbolt.Tx, id []byte) error) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		id, err := backendID(tx, uri, true)
+		if err != nil {
+			return err
+		}
+		return fn(tx, id)
+	})
+}
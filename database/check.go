@@ -0,0 +1,163 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/abibby/backup/backend"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// Check looks for inconsistencies in the database and returns a description
+// of each one it finds.
+func (db *DB) Check() ([]string, error) {
+	problems := []string{}
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		v, err := version(tx)
+		if err != nil {
+			return err
+		}
+		if v != schemaVersion {
+			add("schema version is %d, expected %d", v, schemaVersion)
+		}
+
+		known := map[string]bool{}
+		for _, name := range [][]byte{
+			metaBucket, backendIDsBucket, backendsBucket, repositoriesBucket, aliasBucket, filesBucket,
+			indexBucket, failedBucket, queueBucket, runsBucket, backendRunsBucket,
+			syncedBucket, reconcileBucket,
+		} {
+			known[string(name)] = true
+		}
+		err = tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if !known[string(name)] {
+				add("unknown bucket %q", name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		ids := map[string]bool{}
+		err = forEach(tx, backendsBucket, func(id, uri []byte) error {
+			ids[string(id)] = true
+			mapped, err := backendID(tx, string(uri), false)
+			if err != nil {
+				return err
+			}
+			if string(mapped) != string(id) {
+				add("backend %s: its uri %s belongs to %q", id, uri, mapped)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = forEach(tx, backendIDsBucket, func(uri, id []byte) error {
+			if !ids[string(id)] {
+				add("uri %s: unknown backend %s", uri, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = forEach(tx, aliasBucket, func(key, uri []byte) error {
+			id, err := backendID(tx, string(uri), false)
+			if err != nil {
+				return err
+			}
+			if id == nil {
+				add("alias %s: uri %s has no backend", key, uri)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range [][]byte{repositoriesBucket, filesBucket, indexBucket, failedBucket, queueBucket, backendRunsBucket, syncedBucket} {
+			err = forEach(tx, name, func(id, v []byte) error {
+				if !ids[string(id)] {
+					add("%s: unknown backend %s", name, id)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = forEachNested(tx, filesBucket, func(id, k, v []byte) error {
+			f := &FileRecord{}
+			err := json.Unmarshal(v, f)
+			if err != nil {
+				add("backend %s: %s: invalid file record: %v", id, k, err)
+				return nil
+			}
+			for _, p := range checkFile(string(k), f) {
+				add("backend %s: %s: %s", id, k, p)
+			}
+
+			index := nested(tx, indexBucket, id)
+			if index == nil {
+				add("backend %s: %s: latest version isn't in the index", id, k)
+				return nil
+			}
+			e, err := getIndexEntry(index, string(k))
+			if err != nil {
+				add("backend %s: %s: %v", id, k, err)
+			} else if !slices.ContainsFunc(e.Versions, f.Modified.Equal) {
+				add("backend %s: %s: latest version isn't in the index", id, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return forEachNested(tx, queueBucket, func(id, k, v []byte) error {
+			f := &QueuedFile{}
+			err := json.Unmarshal(v, f)
+			if err != nil {
+				add("backend %s: invalid queued file %s: %v", id, k, err)
+				return nil
+			}
+			if f.Staged == "" {
+				return nil
+			}
+			if _, err := os.Stat(f.Staged); err != nil {
+				add("backend %s: queued file %s: staged copy %s is missing", id, f.Path, f.Staged)
+			}
+			return nil
+		})
+	})
+	return problems, errors.Wrap(err, "failed to read database")
+}
+
+func checkFile(path string, f *FileRecord) []string {
+	problems := []string{}
+	if len(f.Versions) == 0 {
+		problems = append(problems, "no versions")
+	}
+	for i, v := range f.Versions {
+		if i > 0 && !f.Versions[i-1].Time.Before(v.Time) {
+			problems = append(problems, "versions aren't in order")
+		}
+		if want := backend.VersionKey(path, v.Time); v.Key != want {
+			problems = append(problems, fmt.Sprintf("version %d has key %q, expected %q", v.Time.Unix(), v.Key, want))
+		}
+	}
+	if !slices.ContainsFunc(f.Versions, func(v Version) bool { return v.Time.Equal(f.Modified) }) {
+		problems = append(problems, "latest version isn't in its versions")
+	}
+	return problems
+}
@@ -1,7 +1,8 @@
 package database
 
 import (
-	"encoding/binary"
+	"io"
+	"os"
 	"time"
 
 	"github.com/abibby/backup/backend"
@@ -11,8 +12,13 @@ import (
 
 type DB struct {
 	db *bbolt.DB
+	// temp is set to the path of a temporary database that should be removed
+	// when it is closed.
+	temp string
 }
 
+// Open opens the database at path, creating it if it doesn't exist, and
+// migrates it to the current schema.
 func Open(path string) (*DB, error) {
 	db, err := bbolt.Open(path, 0644, &bbolt.Options{
 		Timeout:      time.Second * 10,
@@ -23,15 +29,102 @@ func Open(path string) (*DB, error) {
 		return nil, errors.Wrap(err, "failed to open database")
 	}
 
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &DB{
 		db: db,
-	}, err
+	}, nil
+}
+
+// OpenReadOnly opens the database without allowing any writes. If the
+// database doesn't exist an empty temporary one is used instead, if it uses an
+// older schema a migrated temporary copy is used.
+func OpenReadOnly(path string) (*DB, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return openTemp(nil)
+	}
+
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{
+		Timeout:  time.Second * 10,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+
+	v, err := schemaVersionOf(db)
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to read database")
+	}
+	if v < schemaVersion {
+		db.Close()
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open database")
+		}
+		defer f.Close()
+		return openTemp(f)
+	}
+
+	return &DB{
+		db: db,
+	}, nil
+}
+
+// Replace moves the database at src over the one at path. It fails instead of
+// replacing a database that another process has open, which would keep
+// writing to the replaced file.
+func Replace(path, src string) error {
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: time.Second})
+	if err == bbolt.ErrTimeout {
+		return errors.Errorf("%s is in use by another process", path)
+	} else if err == nil {
+		// hold the lock until the database has been replaced
+		defer db.Close()
+	}
+	// a database that can't be opened can't be in use either
+	return os.Rename(src, path)
+}
+
+// openTemp opens a temporary database with the contents of r, or an empty one
+// if r is nil.
+func openTemp(r io.Reader) (*DB, error) {
+	f, err := os.CreateTemp("", "backup-db-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary database")
+	}
+	if r != nil {
+		_, err = io.Copy(f, r)
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, errors.Wrap(err, "failed to create temporary database")
+	}
+
+	db, err := Open(f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	db.temp = f.Name()
+	return db, nil
 }
 
 func (db *DB) InitializeBackends(backends []backend.Backend) error {
 	err := db.db.Update(func(tx *bbolt.Tx) error {
 		for _, b := range backends {
-			_, err := tx.CreateBucketIfNotExists([]byte(b.URI()))
+			id, err := backendID(tx, b.URI(), true)
+			if err != nil {
+				return err
+			}
+			_, err = createNested(tx, filesBucket, id)
 			if err != nil {
 				return err
 			}
@@ -45,41 +138,45 @@ func (db *DB) InitializeBackends(backends []backend.Backend) error {
 }
 
 func (db *DB) GetUpdatedTime(b backend.Backend, path string) (int64, error) {
-	var t int64
-	err := db.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(b.URI()))
-		by := b.Get([]byte(path))
-
-		if by != nil {
-			t = int64(binary.LittleEndian.Uint64(by))
-		}
-
-		return nil
-	})
-
-	return t, errors.Wrap(err, "failed to read database")
+	f, err := db.GetFile(b, path)
+	if err != nil || f == nil {
+		return 0, err
+	}
+	return f.Modified.Unix(), nil
 }
 
 func (db *DB) SetUpdatedTime(b backend.Backend, path string, t time.Time) error {
-	err := db.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(b.URI()))
-		return SetUpdatedTime(bucket, path, t)
-	})
-
-	return errors.Wrap(err, "failed to update database")
+	return db.SetFile(b, path, &FileRecord{Modified: t})
 }
 
-func SetUpdatedTime(bucket *bbolt.Bucket, path string, t time.Time) error {
-	timeBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(timeBytes, uint64(t.Unix()))
-	return bucket.Put([]byte(path), timeBytes)
+// Update calls callback in a writable transaction with the id of b, creating
+// one if b hasn't been used before.
+func (db *DB) Update(b backend.Backend, callback func(tx *bbolt.Tx, id []byte) error) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		id, err := backendID(tx, b.URI(), true)
+		if err != nil {
+			return err
+		}
+		return callback(tx, id)
+	})
 }
 
-func (db *DB) Update(b backend.Backend, callback func(tx *bbolt.Tx, bucketName []byte) error) error {
-	return db.db.Update(func(tx *bbolt.Tx) error {
-		return callback(tx, []byte(b.URI()))
+// View calls callback in a read only transaction with the id of b. The id is
+// nil if b hasn't been used before.
+func (db *DB) View(b backend.Backend, callback func(tx *bbolt.Tx, id []byte) error) error {
+	return db.db.View(func(tx *bbolt.Tx) error {
+		id, err := backendID(tx, b.URI(), false)
+		if err != nil {
+			return err
+		}
+		return callback(tx, id)
 	})
 }
+
 func (db *DB) Close() error {
-	return db.db.Close()
+	err := db.db.Close()
+	if db.temp != "" {
+		os.Remove(db.temp)
+	}
+	return err
 }
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// A Record is one line of an exported database. Type says which of the other
+// fields are set.
+type Record struct {
+	Type string `json:"type"`
+	// Version is the schema version of a schema record.
+	Version int `json:"version,omitempty"`
+	// Backend is the id of the backend the record belongs to.
+	Backend string `json:"backend,omitempty"`
+	// URI is the current uri of a backend or the uri of an alias. URIs holds
+	// every uri a backend has had.
+	URI  string   `json:"uri,omitempty"`
+	URIs []string `json:"uris,omitempty"`
+	// Repository is the identity of the files stored in a backend.
+	Repository string `json:"repository,omitempty"`
+	// Key is the name of a backend in the config.
+	Key        string      `json:"key,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Time       *time.Time  `json:"time,omitempty"`
+	File       *FileRecord `json:"file,omitempty"`
+	Index      *IndexEntry `json:"index,omitempty"`
+	Queued     *QueuedFile `json:"queued,omitempty"`
+	BackendRun *BackendRun `json:"backend_run,omitempty"`
+	Run        *RunState   `json:"run,omitempty"`
+}
+
+const (
+	RecordSchema     = "schema"
+	RecordBackend    = "backend"
+	RecordAlias      = "alias"
+	RecordFile       = "file"
+	RecordIndex      = "index"
+	RecordFailed     = "failed"
+	RecordQueued     = "queued"
+	RecordBackendRun = "backend-run"
+	RecordSynced     = "synced"
+	RecordRun        = "run"
+)
+
+// importBatchSize is the number of records written in each transaction of an
+// import.
+const importBatchSize = 10000
+
+// Export writes the database to w as JSON lines, one Record per line. The
+// progress of interrupted reconciles isn't exported.
+func (db *DB) Export(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	e := json.NewEncoder(bw)
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		v, err := version(tx)
+		if err != nil {
+			return err
+		}
+		err = e.Encode(&Record{Type: RecordSchema, Version: v})
+		if err != nil {
+			return err
+		}
+
+		uris := map[string][]string{}
+		if ids := tx.Bucket(backendIDsBucket); ids != nil {
+			err = ids.ForEach(func(k, v []byte) error {
+				uris[string(v)] = append(uris[string(v)], string(k))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = forEach(tx, backendsBucket, func(k, v []byte) error {
+			return e.Encode(&Record{
+				Type:       RecordBackend,
+				Backend:    string(k),
+				URI:        string(v),
+				URIs:       uris[string(k)],
+				Repository: backendRepository(tx, k),
+			})
+		})
+		if err != nil {
+			return err
+		}
+		err = forEach(tx, aliasBucket, func(k, v []byte) error {
+			return e.Encode(&Record{Type: RecordAlias, Key: string(k), URI: string(v)})
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEachNested(tx, filesBucket, func(id, k, v []byte) error {
+			f := &FileRecord{}
+			err := json.Unmarshal(v, f)
+			if err != nil {
+				return errors.Wrapf(err, "invalid file record for %s", k)
+			}
+			return e.Encode(&Record{Type: RecordFile, Backend: string(id), Path: string(k), File: f})
+		})
+		if err != nil {
+			return err
+		}
+		err = forEachNested(tx, indexBucket, func(id, k, v []byte) error {
+			entry := &IndexEntry{}
+			err := json.Unmarshal(v, entry)
+			if err != nil {
+				return errors.Wrapf(err, "invalid index entry for %s", k)
+			}
+			return e.Encode(&Record{Type: RecordIndex, Backend: string(id), Path: string(k), Index: entry})
+		})
+		if err != nil {
+			return err
+		}
+		err = forEachNested(tx, failedBucket, func(id, k, v []byte) error {
+			return e.Encode(&Record{Type: RecordFailed, Backend: string(id), Path: string(k), Error: string(v)})
+		})
+		if err != nil {
+			return err
+		}
+		err = forEachNested(tx, queueBucket, func(id, k, v []byte) error {
+			f := &QueuedFile{}
+			err := json.Unmarshal(v, f)
+			if err != nil {
+				return errors.Wrapf(err, "invalid queued file %s", k)
+			}
+			return e.Encode(&Record{Type: RecordQueued, Backend: string(id), Queued: f})
+		})
+		if err != nil {
+			return err
+		}
+		err = forEach(tx, backendRunsBucket, func(k, v []byte) error {
+			run := &BackendRun{}
+			err := json.Unmarshal(v, run)
+			if err != nil {
+				return errors.Wrapf(err, "invalid backend run for %s", k)
+			}
+			return e.Encode(&Record{Type: RecordBackendRun, Backend: string(k), BackendRun: run})
+		})
+		if err != nil {
+			return err
+		}
+		err = forEach(tx, syncedBucket, func(k, v []byte) error {
+			t := time.Unix(0, int64(binary.LittleEndian.Uint64(v)))
+			return e.Encode(&Record{Type: RecordSynced, Backend: string(k), Time: &t})
+		})
+		if err != nil {
+			return err
+		}
+		if runs := tx.Bucket(runsBucket); runs != nil {
+			if v := runs.Get(lastRunKey); v != nil {
+				run := &RunState{}
+				err = json.Unmarshal(v, run)
+				if err != nil {
+					return errors.Wrap(err, "invalid run state")
+				}
+				return e.Encode(&Record{Type: RecordRun, Run: run})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to export database")
+	}
+	return bw.Flush()
+}
+
+// firstExportVersion is the oldest schema version that can be exported.
+const firstExportVersion = 2
+
+// Import loads records written by Export into the database. The first record
+// must be a schema record. Exports from older schema versions are migrated
+// once they are loaded.
+func (db *DB) Import(r io.Reader) error {
+	d := json.NewDecoder(r)
+	first := true
+	line := 0
+	for {
+		records := make([]*Record, 0, importBatchSize)
+		for len(records) < importBatchSize {
+			rec := &Record{}
+			err := d.Decode(rec)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return errors.Wrapf(err, "invalid record %d", line+len(records)+1)
+			}
+			records = append(records, rec)
+		}
+		if first {
+			if len(records) == 0 || records[0].Type != RecordSchema {
+				return fmt.Errorf("the export doesn't start with a schema record")
+			}
+			if v := records[0].Version; v < firstExportVersion || v > schemaVersion {
+				return fmt.Errorf("the export has schema version %d, expected %d to %d", v, firstExportVersion, schemaVersion)
+			}
+			first = false
+		}
+		if len(records) == 0 {
+			return migrate(db.db)
+		}
+
+		err := db.db.Update(func(tx *bbolt.Tx) error {
+			for i, rec := range records {
+				err := importRecord(tx, rec)
+				if err != nil {
+					return errors.Wrapf(err, "record %d", line+i+1)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to import database")
+		}
+		line += len(records)
+	}
+}
+
+func importRecord(tx *bbolt.Tx, rec *Record) error {
+	id := []byte(rec.Backend)
+	if rec.Type != RecordSchema && rec.Type != RecordAlias && rec.Type != RecordRun && rec.Backend == "" {
+		return fmt.Errorf("%s record has no backend", rec.Type)
+	}
+
+	switch rec.Type {
+	case RecordSchema:
+		return setVersion(tx, rec.Version)
+	case RecordBackend:
+		for _, uri := range rec.URIs {
+			err := setBackendID(tx, uri, id)
+			if err != nil {
+				return err
+			}
+		}
+		if rec.Repository != "" {
+			err := setBackendRepository(tx, id, rec.Repository)
+			if err != nil {
+				return err
+			}
+		}
+		return setBackendID(tx, rec.URI, id)
+	case RecordAlias:
+		bucket, err := tx.CreateBucketIfNotExists(aliasBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(rec.Key), []byte(rec.URI))
+	case RecordFile:
+		if rec.File == nil {
+			return fmt.Errorf("file record for %s has no file", rec.Path)
+		}
+		bucket, err := createNested(tx, filesBucket, id)
+		if err != nil {
+			return err
+		}
+		return putFile(bucket, rec.Path, rec.File)
+	case RecordIndex:
+		if rec.Index == nil {
+			return fmt.Errorf("index record for %s has no entry", rec.Path)
+		}
+		bucket, err := createNested(tx, indexBucket, id)
+		if err != nil {
+			return err
+		}
+		return putIndexEntry(bucket, rec.Path, rec.Index)
+	case RecordFailed:
+		bucket, err := createNested(tx, failedBucket, id)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(rec.Path), []byte(rec.Error))
+	case RecordQueued:
+		if rec.Queued == nil {
+			return fmt.Errorf("queued record has no file")
+		}
+		bucket, err := createNested(tx, queueBucket, id)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(rec.Queued)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(rec.Queued.key(), b)
+	case RecordBackendRun:
+		if rec.BackendRun == nil {
+			return fmt.Errorf("backend-run record has no run")
+		}
+		return putJSON(tx, backendRunsBucket, id, rec.BackendRun)
+	case RecordSynced:
+		if rec.Time == nil {
+			return fmt.Errorf("synced record has no time")
+		}
+		return setSynced(tx, id, *rec.Time)
+	case RecordRun:
+		if rec.Run == nil {
+			return fmt.Errorf("run record has no run")
+		}
+		return putJSON(tx, runsBucket, lastRunKey, rec.Run)
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+}
+
+// putJSON stores v as JSON under key in the top level bucket name.
+func putJSON(tx *bbolt.Tx, name, key []byte, v any) error {
+	bucket, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, b)
+}
+
+// forEach calls fn with every key and value in the top level bucket name.
+func forEach(tx *bbolt.Tx, name []byte, fn func(k, v []byte) error) error {
+	bucket := tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(fn)
+}
+
+// forEachNested calls fn with every key and value in the backend buckets
+// inside the top level bucket name.
+func forEachNested(tx *bbolt.Tx, name []byte, fn func(id, k, v []byte) error) error {
+	parent := tx.Bucket(name)
+	if parent == nil {
+		return nil
+	}
+	return parent.ForEach(func(id, v []byte) error {
+		bucket := parent.Bucket(id)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			return fn(id, k, v)
+		})
+	})
+}
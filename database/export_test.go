@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestExportImport(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	b := backend.NewFile("/backups")
+	require.NoError(t, db.SetBackendURI("backups", b.URI(), "repo"))
+	require.NoError(t, db.SetFile(b, "/a.txt", &FileRecord{Modified: time.Unix(1000, 0), Hash: "abc", Size: 3}))
+	require.NoError(t, db.SetUpdatedTime(b, "/a.txt", time.Unix(2000, 0)))
+	require.NoError(t, db.Enqueue(b.URI(), &QueuedFile{Path: "/b.txt", Modified: time.Unix(1000, 0)}))
+	require.NoError(t, db.SetBackendRun(b.URI(), &BackendRun{Uploaded: 1}))
+	require.NoError(t, db.SetSynced(b.URI(), time.Unix(3000, 0)))
+	require.NoError(t, db.SetRunState(&RunState{Failed: true}))
+
+	problems, err := db.Check()
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	var exported bytes.Buffer
+	require.NoError(t, db.Export(&exported))
+
+	imported, err := Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer imported.Close()
+	require.NoError(t, imported.Import(bytes.NewReader(exported.Bytes())))
+
+	var reexported bytes.Buffer
+	require.NoError(t, imported.Export(&reexported))
+	assert.Equal(t, exported.String(), reexported.String())
+
+	f, err := imported.GetFile(b, "/a.txt")
+	require.NoError(t, err)
+	assert.Len(t, f.Versions, 2)
+}
+
+func TestCheck(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	b := backend.NewFile("/backups")
+	require.NoError(t, db.SetUpdatedTime(b, "/a.txt", time.Unix(1000, 0)))
+	err = db.Update(b, func(tx *bbolt.Tx, id []byte) error {
+		return putFile(FilesBucket(tx, id), "/b.txt", &FileRecord{Modified: time.Unix(1000, 0)})
+	})
+	require.NoError(t, err)
+
+	problems, err := db.Check()
+	require.NoError(t, err)
+	assert.Len(t, problems, 3)
+}
+
+func TestReplace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.bolt")
+	src := filepath.Join(dir, "import.bolt")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	imported, err := Open(src)
+	require.NoError(t, err)
+	require.NoError(t, imported.Close())
+
+	// a database that is open isn't replaced
+	assert.Error(t, Replace(path, src))
+	require.NoError(t, db.Close())
+	assert.NoError(t, Replace(path, src))
+	assert.NoFileExists(t, src)
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"github.com/abibby/backup/backend"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var failedBucket = []byte("failed")
+
+// SetFailed records that path could not be backed up to b. It is cleared the
+// next time the file is backed up successfully.
+func (db *DB) SetFailed(b backend.Backend, path string, failure error) error {
+	err := db.Update(b, func(tx *bbolt.Tx, id []byte) error {
+		bucket, err := createNested(tx, failedBucket, id)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(path), []byte(failure.Error()))
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+// ClearFailed removes the failure recorded for path, if there is one.
+func (db *DB) ClearFailed(b backend.Backend, path string) error {
+	err := db.Update(b, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, failedBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(path))
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+// Failed returns the files that failed to back up to the backend with the
+// given uri and their errors.
+func (db *DB) Failed(uri string) (map[string]string, error) {
+	files := map[string]string{}
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, failedBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			files[string(k)] = string(v)
+			return nil
+		})
+	})
+	return files, errors.Wrap(err, "failed to read database")
+}
+
+// FileCount returns the number of files recorded as backed up to the backend
+// with the given uri.
+func (db *DB) FileCount(uri string) (int, error) {
+	n := 0
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := FilesBucket(tx, id)
+		if bucket == nil {
+			return nil
+		}
+		n = bucket.Stats().KeyN
+		return nil
+	})
+	return n, errors.Wrap(err, "failed to read database")
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"encoding/json"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var filesBucket = []byte("files")
+
+type Version struct {
+	Time time.Time `json:"time"`
+	// Key is where the version is stored relative to the root of the
+	// backend.
+	Key string `json:"key"`
+}
+
+// A FileRecord is what the database knows about a file that was backed up to
+// a backend.
+type FileRecord struct {
+	// Modified is the modification time of the latest version that was
+	// backed up.
+	Modified time.Time `json:"modified"`
+	// Size, Hash and Mode describe the latest version. They are empty if it
+	// wasn't uploaded by a backup, e.g. if it was found by reconcile. Hash is
+	// the hex encoded SHA-256 of the file's contents.
+	Size int64       `json:"size,omitempty"`
+	Hash string      `json:"hash,omitempty"`
+	Mode fs.FileMode `json:"mode,omitempty"`
+	// RunID is the id of the backup that uploaded the latest version.
+	RunID    string    `json:"run_id,omitempty"`
+	Versions []Version `json:"versions"`
+}
+
+func (f *FileRecord) addVersion(path string, t time.Time) {
+	t = time.Unix(t.Unix(), 0)
+	i, found := slices.BinarySearchFunc(f.Versions, t, func(v Version, t time.Time) int {
+		return v.Time.Compare(t)
+	})
+	if !found {
+		f.Versions = slices.Insert(f.Versions, i, Version{Time: t, Key: backend.VersionKey(path, t)})
+	}
+}
+
+// FilesBucket returns the bucket holding the file records of the backend with
+// the given id or nil if there isn't one.
+func FilesBucket(tx *bbolt.Tx, id []byte) *bbolt.Bucket {
+	return nested(tx, filesBucket, id)
+}
+
+// GetFile returns the record of path in bucket or nil if there isn't one.
+func GetFile(bucket *bbolt.Bucket, path string) (*FileRecord, error) {
+	if bucket == nil {
+		return nil, nil
+	}
+	b := bucket.Get([]byte(path))
+	if b == nil {
+		return nil, nil
+	}
+	f := &FileRecord{}
+	err := json.Unmarshal(b, f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid file record for %s", path)
+	}
+	return f, nil
+}
+
+func putFile(bucket *bbolt.Bucket, path string, f *FileRecord) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(path), b)
+}
+
+// SetVersions records that versions of path are in the backend and that the
+// last one is its latest version.
+func SetVersions(bucket *bbolt.Bucket, path string, versions []time.Time) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	f, err := GetFile(bucket, path)
+	if err != nil {
+		return err
+	}
+	latest := versions[len(versions)-1]
+	if f == nil {
+		f = &FileRecord{}
+	} else if f.Modified.Unix() != latest.Unix() {
+		// the details belong to a different version
+		f = &FileRecord{Versions: f.Versions}
+	}
+	f.Modified = time.Unix(latest.Unix(), 0)
+	for _, v := range versions {
+		f.addVersion(path, v)
+	}
+	return putFile(bucket, path, f)
+}
+
+// GetFile returns the record of path in b or nil if it hasn't been backed up.
+func (db *DB) GetFile(b backend.Backend, path string) (*FileRecord, error) {
+	var f *FileRecord
+	err := db.View(b, func(tx *bbolt.Tx, id []byte) error {
+		var err error
+		f, err = GetFile(FilesBucket(tx, id), path)
+		return err
+	})
+	return f, errors.Wrap(err, "failed to read database")
+}
+
+// SetFile records that the version of path modified at f.Modified was backed
+// up to b. Versions recorded before are kept.
+func (db *DB) SetFile(b backend.Backend, path string, f *FileRecord) error {
+	err := db.Update(b, func(tx *bbolt.Tx, id []byte) error {
+		bucket, err := createNested(tx, filesBucket, id)
+		if err != nil {
+			return err
+		}
+		old, err := GetFile(bucket, path)
+		if err != nil {
+			return err
+		}
+		record := *f
+		record.Modified = time.Unix(f.Modified.Unix(), 0)
+		record.Versions = nil
+		if old != nil {
+			record.Versions = old.Versions
+		}
+		record.addVersion(path, f.Modified)
+		err = putFile(bucket, path, &record)
+		if err != nil {
+			return err
+		}
+
+		err = IndexVersions(tx, id, path, []time.Time{f.Modified})
+		if err != nil {
+			return err
+		}
+		if failed := nested(tx, failedBucket, id); failed != nil {
+			return failed.Delete([]byte(path))
+		}
+		return nil
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+func CreateFilesBucket(tx *bbolt.Tx, id []byte) (*bbolt.Bucket, error) {
+	return createNested(tx, filesBucket, id)
+}
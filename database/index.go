@@ -0,0 +1,196 @@
+package database
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var indexBucket = []byte("index")
+
+// An IndexEntry records the history of a path in a backend.
+type IndexEntry struct {
+	Versions []time.Time `json:"versions"`
+	// Deleted holds the times the file was found to be missing from disk
+	// after it had been backed up.
+	Deleted []time.Time `json:"deleted,omitempty"`
+}
+
+// A Range is a period a file existed for. To is zero if it still exists.
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+// Ranges returns the periods the file existed for in order.
+func (e *IndexEntry) Ranges() []Range {
+	ranges := []Range{}
+	var from time.Time
+	d := 0
+	for _, v := range e.Versions {
+		for d < len(e.Deleted) && e.Deleted[d].Before(v) {
+			if !from.IsZero() {
+				ranges = append(ranges, Range{From: from, To: e.Deleted[d]})
+				from = time.Time{}
+			}
+			d++
+		}
+		if from.IsZero() {
+			from = v
+		}
+	}
+	if !from.IsZero() {
+		r := Range{From: from}
+		if d < len(e.Deleted) {
+			r.To = e.Deleted[d]
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// DeletedBetween reports whether the file was found to be deleted after from
+// and at or before to. A zero to means now.
+func (e *IndexEntry) DeletedBetween(from, to time.Time) bool {
+	for _, d := range e.Deleted {
+		if d.After(from) && (to.IsZero() || !d.After(to)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *IndexEntry) addVersion(t time.Time) {
+	if slices.ContainsFunc(e.Versions, t.Equal) {
+		return
+	}
+	e.Versions = append(e.Versions, t)
+	slices.SortFunc(e.Versions, func(a, b time.Time) int {
+		return a.Compare(b)
+	})
+}
+
+// IndexVersions adds versions to the index entry for path in the backend with
+// the given id.
+func IndexVersions(tx *bbolt.Tx, id []byte, path string, versions []time.Time) error {
+	bucket, err := createNested(tx, indexBucket, id)
+	if err != nil {
+		return err
+	}
+	e, err := getIndexEntry(bucket, path)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		e.addVersion(v.Truncate(time.Second))
+	}
+	return putIndexEntry(bucket, path, e)
+}
+
+// MarkDeleted removes every file that exists returns false for from the
+// backend's updated times and records that it was deleted at t. It returns the
+// number of files that were removed.
+func (db *DB) MarkDeleted(b backend.Backend, exists func(path string) bool, t time.Time) (int, error) {
+	n := 0
+	err := db.Update(b, func(tx *bbolt.Tx, id []byte) error {
+		bucket := FilesBucket(tx, id)
+		if bucket == nil {
+			return nil
+		}
+		deleted := [][]byte{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			if !exists(string(k)) {
+				deleted = append(deleted, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		index, err := createNested(tx, indexBucket, id)
+		if err != nil {
+			return err
+		}
+		for _, k := range deleted {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+			e, err := getIndexEntry(index, string(k))
+			if err != nil {
+				return err
+			}
+			e.Deleted = append(e.Deleted, t.Truncate(time.Second))
+			err = putIndexEntry(index, string(k), e)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(deleted)
+		return nil
+	})
+	return n, errors.Wrap(err, "failed to update database")
+}
+
+// IndexedBackends returns the uri of every backend with an index.
+func (db *DB) IndexedBackends() ([]string, error) {
+	uris := []string{}
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(indexBucket)
+		if index == nil {
+			return nil
+		}
+		return index.ForEach(func(k, v []byte) error {
+			if uri := backendURI(tx, k); uri != "" {
+				uris = append(uris, uri)
+			}
+			return nil
+		})
+	})
+	return uris, errors.Wrap(err, "failed to read database")
+}
+
+// Index calls fn with every path in the index of the backend with the given
+// uri in lexical order.
+func (db *DB) Index(uri string, fn func(path string, e *IndexEntry) error) error {
+	return db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, indexBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			e := &IndexEntry{}
+			err := json.Unmarshal(v, e)
+			if err != nil {
+				return errors.Wrapf(err, "invalid index entry for %s", k)
+			}
+			return fn(string(k), e)
+		})
+	})
+}
+
+func getIndexEntry(bucket *bbolt.Bucket, path string) (*IndexEntry, error) {
+	e := &IndexEntry{Versions: []time.Time{}}
+	b := bucket.Get([]byte(path))
+	if b == nil {
+		return e, nil
+	}
+	err := json.Unmarshal(b, e)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid index entry for %s", path)
+	}
+	return e, nil
+}
+
+func putIndexEntry(bucket *bbolt.Bucket, path string, e *IndexEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(path), b)
+}
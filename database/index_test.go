@@ -0,0 +1,27 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexEntryRanges(t *testing.T) {
+	at := func(unix int64) time.Time { return time.Unix(unix, 0) }
+
+	e := &IndexEntry{
+		Versions: []time.Time{at(10), at(20), at(40)},
+		Deleted:  []time.Time{at(30), at(50)},
+	}
+	assert.Equal(t, []Range{
+		{From: at(10), To: at(30)},
+		{From: at(40), To: at(50)},
+	}, e.Ranges())
+	assert.True(t, e.DeletedBetween(at(20), at(35)))
+	assert.False(t, e.DeletedBetween(at(40), at(45)))
+	assert.True(t, e.DeletedBetween(at(40), time.Time{}))
+
+	e = &IndexEntry{Versions: []time.Time{at(10)}}
+	assert.Equal(t, []Range{{From: at(10)}}, e.Ranges())
+}
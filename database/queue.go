@@ -0,0 +1,200 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	aliasBucket = []byte("backend-aliases")
+	queueBucket = []byte("queue")
+)
+
+type QueuedFile struct {
+	Path     string    `json:"path"`
+	Modified time.Time `json:"modified"`
+	// Staged is the path to a local copy of the file taken when it was queued.
+	// If it is empty the file is read from Path when the queue is flushed.
+	Staged string `json:"staged,omitempty"`
+	// Virtual is set for files that don't exist on disk, such as streams.
+	// They are always staged and never read from Path.
+	Virtual bool `json:"virtual,omitempty"`
+	// Record is stored as the backend's record of the file once it has been
+	// flushed to it.
+	Record *FileRecord `json:"record,omitempty"`
+}
+
+func queuedKey(path string, modified time.Time) []byte {
+	return []byte(fmt.Sprintf("%s-%d", path, modified.Unix()))
+}
+
+func (f *QueuedFile) key() []byte {
+	return queuedKey(f.Path, f.Modified)
+}
+
+// SetBackendURI remembers the URI a configured backend had the last time it
+// was loaded so files can be queued for it while it is unreachable. If the
+// URI has changed since then and the backend still stores the same
+// repository, identified by backend.Identity, it keeps its id so its files
+// aren't backed up again. Otherwise the new URI is treated as a new backend.
+func (db *DB) SetBackendURI(key, uri, repository string) error {
+	err := db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(aliasBucket)
+		if err != nil {
+			return err
+		}
+		id, err := backendID(tx, uri, false)
+		if err != nil {
+			return err
+		}
+		old := string(bucket.Get([]byte(key)))
+		if id == nil && old != "" && old != uri && repository != "" {
+			oldID, err := backendID(tx, old, false)
+			if err != nil {
+				return err
+			}
+			if oldID != nil && backendRepository(tx, oldID) == repository {
+				slog.Info("backend uri changed", "backend", key, "from", old, "to", uri)
+				err = setBackendID(tx, uri, oldID)
+				if err != nil {
+					return err
+				}
+				id = oldID
+			}
+		}
+		if repository != "" {
+			if id == nil {
+				id, err = backendID(tx, uri, true)
+				if err != nil {
+					return err
+				}
+			}
+			err = setBackendRepository(tx, id, repository)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.Put([]byte(key), []byte(uri))
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+func (db *DB) GetBackendURI(key string) (string, error) {
+	uri := ""
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(aliasBucket)
+		if bucket == nil {
+			return nil
+		}
+		uri = string(bucket.Get([]byte(key)))
+		return nil
+	})
+	return uri, errors.Wrap(err, "failed to read database")
+}
+
+func (db *DB) Enqueue(uri string, f *QueuedFile) error {
+	err := db.updateURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket, err := createNested(tx, queueBucket, id)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(f.key(), b)
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+// SetQueuedRecord sets the record of the queued version of path modified at
+// record.Modified. It is recorded as backed up once the queue is flushed.
+func (db *DB) SetQueuedRecord(uri, path string, record *FileRecord) error {
+	err := db.updateURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, queueBucket, id)
+		if bucket == nil {
+			return errors.Errorf("%s isn't queued", path)
+		}
+		key := queuedKey(path, record.Modified)
+		v := bucket.Get(key)
+		if v == nil {
+			return errors.Errorf("%s isn't queued", path)
+		}
+		f := &QueuedFile{}
+		err := json.Unmarshal(v, f)
+		if err != nil {
+			return err
+		}
+		f.Record = record
+		b, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, b)
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+// IsQueued returns true if the version of path modified at modified is
+// waiting to be flushed to the backend with the given uri.
+func (db *DB) IsQueued(uri, path string, modified time.Time) (bool, error) {
+	queued := false
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, queueBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		queued = bucket.Get(queuedKey(path, modified)) != nil
+		return nil
+	})
+	return queued, errors.Wrap(err, "failed to read database")
+}
+
+func (db *DB) Dequeue(uri string, f *QueuedFile) error {
+	err := db.updateURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, queueBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete(f.key())
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+func (db *DB) Queued(uri string) ([]*QueuedFile, error) {
+	files := []*QueuedFile{}
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, queueBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			f := &QueuedFile{}
+			err := json.Unmarshal(v, f)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+			return nil
+		})
+	})
+	return files, errors.Wrap(err, "failed to read database")
+}
+
+func (db *DB) QueueLength(uri string) (int, error) {
+	n := 0
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := nested(tx, queueBucket, id)
+		if bucket == nil {
+			return nil
+		}
+		n = bucket.Stats().KeyN
+		return nil
+	})
+	return n, errors.Wrap(err, "failed to read database")
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	runsBucket = []byte("runs")
+	lastRunKey = []byte("last")
+)
+
+type RunState struct {
+	FirstRun    time.Time     `json:"first_run"`
+	LastRun     time.Time     `json:"last_run"`
+	LastSuccess time.Time     `json:"last_success"`
+	Duration    time.Duration `json:"duration"`
+	Failed      bool          `json:"failed"`
+	Error       string        `json:"error,omitempty"`
+	// StaleNotified is set once a stale backup notification has been sent so
+	// that it is only sent once until the next successful run.
+	StaleNotified bool `json:"stale_notified"`
+}
+
+func (db *DB) GetRunState() (*RunState, error) {
+	state := &RunState{}
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		if bucket == nil {
+			return nil
+		}
+		b := bucket.Get(lastRunKey)
+		if b == nil {
+			return nil
+		}
+		return json.Unmarshal(b, state)
+	})
+	return state, errors.Wrap(err, "failed to read database")
+}
+
+func (db *DB) SetRunState(state *RunState) error {
+	err := db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(lastRunKey, b)
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+var backendRunsBucket = []byte("backend-runs")
+
+type BackendRun struct {
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	Failed      bool      `json:"failed"`
+	RunID       string    `json:"run_id,omitempty"`
+	Uploaded    int       `json:"uploaded"`
+	FailedFiles int       `json:"failed_files"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func (db *DB) GetBackendRun(uri string) (*BackendRun, error) {
+	run := &BackendRun{}
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := tx.Bucket(backendRunsBucket)
+		if bucket == nil || id == nil {
+			return nil
+		}
+		b := bucket.Get(id)
+		if b == nil {
+			return nil
+		}
+		return json.Unmarshal(b, run)
+	})
+	return run, errors.Wrap(err, "failed to read database")
+}
+
+func (db *DB) SetBackendRun(uri string, run *BackendRun) error {
+	err := db.updateURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket, err := tx.CreateBucketIfNotExists(backendRunsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(id, b)
+	})
+	return errors.Wrap(err, "failed to update database")
+}
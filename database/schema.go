@@ -0,0 +1,270 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// schemaVersion is the version of the layout of the database. Databases
+// written by older versions are migrated when they are opened.
+const schemaVersion = 3
+
+var (
+	metaBucket = []byte("meta")
+	versionKey = []byte("version")
+	// reconcileBucket holds the progress of the reconcile package.
+	reconcileBucket = []byte("reconcile")
+)
+
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx *bbolt.Tx) error
+}
+
+var migrations = []migration{
+	{version: 2, description: "key backends by id and store file records", migrate: migrateBackendIDs},
+	{version: 3, description: "add files backed up before the index existed to it", migrate: backfillIndex},
+}
+
+// version returns the schema version of the database, 0 if it is empty.
+func version(tx *bbolt.Tx) (int, error) {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		empty := true
+		err := tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			empty = false
+			return nil
+		})
+		if err != nil || empty {
+			return 0, err
+		}
+		// databases from before the schema was versioned
+		return 1, nil
+	}
+	v, err := strconv.Atoi(string(meta.Get(versionKey)))
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid schema version")
+	}
+	return v, nil
+}
+
+func setVersion(tx *bbolt.Tx, v int) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(versionKey, []byte(strconv.Itoa(v)))
+}
+
+func schemaVersionOf(db *bbolt.DB) (int, error) {
+	v := 0
+	err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		v, err = version(tx)
+		return err
+	})
+	return v, err
+}
+
+// migrate brings the database up to the current schema version.
+func migrate(db *bbolt.DB) error {
+	current, err := schemaVersionOf(db)
+	if err != nil {
+		return err
+	}
+	if current > schemaVersion {
+		return errors.Errorf("database schema version %d is newer than the supported version %d", current, schemaVersion)
+	}
+	if current == 0 {
+		return db.Update(func(tx *bbolt.Tx) error {
+			return setVersion(tx, schemaVersion)
+		})
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		slog.Info("migrating database", "version", m.version, "migration", m.description)
+		err = db.Update(func(tx *bbolt.Tx) error {
+			err := m.migrate(tx)
+			if err != nil {
+				return err
+			}
+			return setVersion(tx, m.version)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate database to version %d", m.version)
+		}
+	}
+	return nil
+}
+
+// migrateBackendIDs moves the data of each backend from buckets keyed by its
+// uri to buckets keyed by its id and replaces the updated times with file
+// records.
+func migrateBackendIDs(tx *bbolt.Tx) error {
+	global := map[string]bool{
+		string(runsBucket):        true,
+		string(backendRunsBucket): true,
+		string(aliasBucket):       true,
+		string(queueBucket):       true,
+		string(failedBucket):      true,
+		string(indexBucket):       true,
+		string(syncedBucket):      true,
+		string(reconcileBucket):   true,
+	}
+	uris := [][]byte{}
+	err := tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		if !global[string(name)] {
+			uris = append(uris, slices.Clone(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, uri := range uris {
+		id, err := backendID(tx, string(uri), true)
+		if err != nil {
+			return err
+		}
+		files, err := createNested(tx, filesBucket, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(uri).ForEach(func(k, v []byte) error {
+			t := time.Unix(int64(binary.LittleEndian.Uint64(v)), 0)
+			return putFile(files, string(k), &FileRecord{
+				Modified: t,
+				Versions: []Version{{Time: t, Key: backend.VersionKey(string(k), t)}},
+			})
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteBucket(uri)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range [][]byte{indexBucket, failedBucket, queueBucket} {
+		err = rekeyBuckets(tx, name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range [][]byte{backendRunsBucket, syncedBucket} {
+		err = rekeyValues(tx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// progress is kept per uri, an interrupted reconcile starts over
+	err = tx.DeleteBucket(reconcileBucket)
+	if err != nil && err != bbolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
+
+// rekeyBuckets moves the buckets inside name from backend uris to ids.
+func rekeyBuckets(tx *bbolt.Tx, name []byte) error {
+	parent := tx.Bucket(name)
+	if parent == nil {
+		return nil
+	}
+	uris := [][]byte{}
+	err := parent.ForEach(func(k, v []byte) error {
+		if v == nil {
+			uris = append(uris, slices.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, uri := range uris {
+		id, err := backendID(tx, string(uri), true)
+		if err != nil {
+			return err
+		}
+		dst, err := parent.CreateBucketIfNotExists(id)
+		if err != nil {
+			return err
+		}
+		err = parent.Bucket(uri).ForEach(func(k, v []byte) error {
+			return dst.Put(k, v)
+		})
+		if err != nil {
+			return err
+		}
+		err = parent.DeleteBucket(uri)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rekeyValues moves the values in name from backend uris to ids.
+func rekeyValues(tx *bbolt.Tx, name []byte) error {
+	bucket := tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	values := map[string][]byte{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		values[string(k)] = slices.Clone(v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for uri, v := range values {
+		id, err := backendID(tx, uri, true)
+		if err != nil {
+			return err
+		}
+		err = bucket.Delete([]byte(uri))
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(id, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillIndex adds the versions of every file record to the index. Files
+// that haven't changed since before the index was added have no entries.
+func backfillIndex(tx *bbolt.Tx) error {
+	return forEachNested(tx, filesBucket, func(id, k, v []byte) error {
+		f := &FileRecord{}
+		err := json.Unmarshal(v, f)
+		if err != nil {
+			return errors.Wrapf(err, "invalid file record for %s", k)
+		}
+		versions := make([]time.Time, len(f.Versions))
+		for i, v := range f.Versions {
+			versions[i] = v.Time
+		}
+		return IndexVersions(tx, id, string(k), versions)
+	})
+}
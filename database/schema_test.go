@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestMigrateBackendIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bolt")
+	uri := "file:///backups"
+	modified := time.Unix(1000, 0)
+
+	// write a database with the unversioned layout
+	old, err := bbolt.Open(path, 0644, nil)
+	require.NoError(t, err)
+	err = old.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte(uri))
+		require.NoError(t, err)
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, uint64(modified.Unix()))
+		require.NoError(t, bucket.Put([]byte("/a.txt"), v))
+
+		failed, err := tx.CreateBucket(failedBucket)
+		require.NoError(t, err)
+		bucket, err = failed.CreateBucket([]byte(uri))
+		require.NoError(t, err)
+		require.NoError(t, bucket.Put([]byte("/b.txt"), []byte("permission denied")))
+
+		runs, err := tx.CreateBucket(backendRunsBucket)
+		require.NoError(t, err)
+		return runs.Put([]byte(uri), []byte(`{"uploaded":3}`))
+	})
+	require.NoError(t, err)
+	require.NoError(t, old.Close())
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	v, err := schemaVersionOf(db.db)
+	require.NoError(t, err)
+	assert.Equal(t, schemaVersion, v)
+
+	b := backend.NewFile("/backups")
+	f, err := db.GetFile(b, "/a.txt")
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	assert.True(t, modified.Equal(f.Modified))
+	require.Len(t, f.Versions, 1)
+	assert.Equal(t, "/a.txt-1000.gz", f.Versions[0].Key)
+
+	failed, err := db.Failed(uri)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/b.txt": "permission denied"}, failed)
+
+	run, err := db.GetBackendRun(uri)
+	require.NoError(t, err)
+	assert.Equal(t, 3, run.Uploaded)
+
+	// files from before the index existed are added to it
+	problems, err := db.Check()
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestBackendURIChange(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	before := backend.NewFile("/mnt/a")
+	moved := backend.NewFile("/mnt/moved")
+	other := backend.NewFile("/mnt/b")
+	require.NoError(t, db.SetBackendURI("backups", before.URI(), "repo-a"))
+	require.NoError(t, db.SetUpdatedTime(before, "/a.txt", time.Unix(1000, 0)))
+
+	// the same repository under a new uri keeps its files
+	require.NoError(t, db.SetBackendURI("backups", moved.URI(), "repo-a"))
+	updated, err := db.GetUpdatedTime(moved, "/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), updated)
+
+	// a different repository starts empty
+	require.NoError(t, db.SetBackendURI("backups", other.URI(), "repo-b"))
+	updated, err = db.GetUpdatedTime(other, "/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+
+	beforeID, err := db.BackendID(before.URI())
+	require.NoError(t, err)
+	movedID, err := db.BackendID(moved.URI())
+	require.NoError(t, err)
+	otherID, err := db.BackendID(other.URI())
+	require.NoError(t, err)
+	assert.Equal(t, beforeID, movedID)
+	assert.NotEqual(t, beforeID, otherID)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var syncedBucket = []byte("synced")
+
+// UpdatedTimes returns the time of the latest version of every file backed up
+// to the backend with the given uri. ok is false if the backend has never
+// been backed up with this database.
+func (db *DB) UpdatedTimes(uri string) (times map[string]time.Time, ok bool, err error) {
+	times = map[string]time.Time{}
+	err = db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := FilesBucket(tx, id)
+		if bucket == nil {
+			return nil
+		}
+		ok = true
+		return bucket.ForEach(func(k, v []byte) error {
+			f := &FileRecord{}
+			err := json.Unmarshal(v, f)
+			if err != nil {
+				return errors.Wrapf(err, "invalid file record for %s", k)
+			}
+			times[string(k)] = f.Modified
+			return nil
+		})
+	})
+	return times, ok, errors.Wrap(err, "failed to read database")
+}
+
+// Restore merges the versions in files into the records of b and records that
+// the database matches the backend as of synced. files lists every file in the
+// backend, the records of other files are removed while their history is
+// kept in the index.
+func (db *DB) Restore(b backend.Backend, files map[string][]time.Time, synced time.Time) error {
+	err := db.Update(b, func(tx *bbolt.Tx, id []byte) error {
+		bucket, err := CreateFilesBucket(tx, id)
+		if err != nil {
+			return err
+		}
+		removed := [][]byte{}
+		err = bucket.ForEach(func(k, v []byte) error {
+			if len(files[string(k)]) == 0 {
+				removed = append(removed, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range removed {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		for p, versions := range files {
+			if len(versions) == 0 {
+				continue
+			}
+			err = SetVersions(bucket, p, versions)
+			if err != nil {
+				return err
+			}
+			err = IndexVersions(tx, id, p, versions)
+			if err != nil {
+				return err
+			}
+		}
+		return setSynced(tx, id, synced)
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+// Synced returns the time the database last matched the state stored in the
+// backend with the given uri.
+func (db *DB) Synced(uri string) (time.Time, error) {
+	var t time.Time
+	err := db.viewURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		bucket := tx.Bucket(syncedBucket)
+		if bucket == nil || id == nil {
+			return nil
+		}
+		if v := bucket.Get(id); v != nil {
+			t = time.Unix(0, int64(binary.LittleEndian.Uint64(v)))
+		}
+		return nil
+	})
+	return t, errors.Wrap(err, "failed to read database")
+}
+
+func (db *DB) SetSynced(uri string, t time.Time) error {
+	err := db.updateURI(uri, func(tx *bbolt.Tx, id []byte) error {
+		return setSynced(tx, id, t)
+	})
+	return errors.Wrap(err, "failed to update database")
+}
+
+func setSynced(tx *bbolt.Tx, id []byte, t time.Time) error {
+	bucket, err := tx.CreateBucketIfNotExists(syncedBucket)
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	binary.LittleEndian.PutUint64(v, uint64(t.UnixNano()))
+	return bucket.Put(id, v)
+}
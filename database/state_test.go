@@ -0,0 +1,42 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreMerges(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	b := backend.NewFile("/backups")
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+	require.NoError(t, db.SetFile(b, "/a.txt", &FileRecord{Modified: t1}))
+	require.NoError(t, db.SetFile(b, "/a.txt", &FileRecord{Modified: t2, Hash: "abc", Size: 3}))
+	require.NoError(t, db.SetFile(b, "/b.txt", &FileRecord{Modified: t1}))
+
+	require.NoError(t, db.Restore(b, map[string][]time.Time{"/a.txt": {t2}}, time.Unix(3000, 0)))
+
+	f, err := db.GetFile(b, "/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", f.Hash)
+	assert.Len(t, f.Versions, 2)
+
+	// files that aren't in the backend any more keep their history
+	f, err = db.GetFile(b, "/b.txt")
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	paths := []string{}
+	require.NoError(t, db.Index(b.URI(), func(p string, e *IndexEntry) error {
+		paths = append(paths, p)
+		return nil
+	}))
+	assert.Equal(t, []string{"/a.txt", "/b.txt"}, paths)
+}
@@ -0,0 +1,124 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"reflect"
+	"time"
+)
+
+const DefaultTimeout = time.Hour
+
+type Hook struct {
+	Command string        `mapstructure:"command"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// ContinueOnError lets the backup carry on if this pre or stream hook
+	// fails.
+	ContinueOnError bool `mapstructure:"continue-on-error"`
+	// Name is the path in the backup that the stdout of a stream hook is saved
+	// as.
+	Name string `mapstructure:"name"`
+}
+
+type Config struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+	Pre     []*Hook       `mapstructure:"pre"`
+	Post    []*Hook       `mapstructure:"post"`
+	Streams []*Hook       `mapstructure:"streams"`
+}
+
+// DecodeHook lets hooks be written as a plain command string in the config.
+func DecodeHook(from, to reflect.Type, data any) (any, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(Hook{}) {
+		return Hook{Command: data.(string)}, nil
+	}
+	return data, nil
+}
+
+func (c *Config) timeout(h *Hook) time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Run runs h with the extra environment variables in env, writing the
+// commands stdout to stdout.
+func (c *Config) Run(h *Hook, env []string, stdout io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout(h))
+	defer cancel()
+
+	slog.Info("running hook", "command", h.Command)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook %q timed out after %s", h.Command, c.timeout(h))
+	} else if err != nil {
+		return fmt.Errorf("hook %q failed: %w", h.Command, err)
+	}
+	return nil
+}
+
+// RunPre runs the pre hooks in order, stopping at the first one that fails
+// unless it is set to continue on error.
+func (c *Config) RunPre(env []string) error {
+	env = append(env, "BACKUP_PHASE=pre")
+	for _, h := range c.Pre {
+		err := c.Run(h, env, os.Stderr)
+		if err != nil {
+			if h.ContinueOnError {
+				slog.Warn("pre hook failed, continuing", "err", err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPost runs every post hook, even if earlier ones fail.
+func (c *Config) RunPost(env []string) error {
+	env = append(env, "BACKUP_PHASE=post")
+	var errs []error
+	for _, h := range c.Post {
+		err := c.Run(h, env, os.Stderr)
+		if err != nil {
+			slog.Error("post hook failed", "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RunStream runs a stream hook, saving its stdout to a temporary file. The
+// caller is responsible for closing and removing the file.
+func (c *Config) RunStream(h *Hook, env []string) (*os.File, error) {
+	f, err := os.CreateTemp("", "backup-stream-")
+	if err != nil {
+		return nil, err
+	}
+
+	env = append(env, "BACKUP_PHASE=stream", "BACKUP_STREAM_NAME="+h.Name)
+	err = c.Run(h, env, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
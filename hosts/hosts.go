@@ -0,0 +1,70 @@
+package hosts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/abibby/backup/backend"
+)
+
+const dir = "hosts"
+
+// A Manifest describes a host that backs up to a shared repository.
+type Manifest struct {
+	Host       string    `json:"host"`
+	Tags       []string  `json:"tags"`
+	Dirs       []string  `json:"dirs"`
+	LastBackup time.Time `json:"last_backup"`
+}
+
+// Write saves m in b. Backends that can't store objects are skipped.
+func Write(b backend.Backend, m *Manifest) error {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return store.PutObject(path.Join(dir, m.Host+".json"), data)
+}
+
+// List returns the manifests of every host in b sorted by name.
+func List(b backend.Backend) ([]*Manifest, error) {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return []*Manifest{}, nil
+	}
+	names, err := store.ListObjects(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %w", err)
+	}
+
+	manifests := []*Manifest{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := store.GetObject(path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host %s: %w", name, err)
+		}
+		m := &Manifest{}
+		err = json.Unmarshal(data, m)
+		if err != nil {
+			slog.Warn("invalid host manifest", "backend", b.URI(), "host", name, "err", err)
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	slices.SortFunc(manifests, func(a, b *Manifest) int {
+		return strings.Compare(a.Host, b.Host)
+	})
+	return manifests, nil
+}
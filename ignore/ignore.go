@@ -0,0 +1,214 @@
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type rule struct {
+	// base is the directory the rule was defined in relative to the root of
+	// the matcher, "" for the root itself.
+	base    string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// Matcher matches paths against rules with the same semantics as .gitignore
+// files. Rules are checked in order and the last one that matches a path
+// decides if it is ignored.
+type Matcher struct {
+	rules []*rule
+}
+
+func New(patterns []string) *Matcher {
+	return (&Matcher{}).Add("", patterns)
+}
+
+// Add returns a new matcher with patterns appended. The patterns are relative
+// to base, a slash separated directory relative to the root of the matcher.
+func (m *Matcher) Add(base string, patterns []string) *Matcher {
+	rules := make([]*rule, len(m.rules), len(m.rules)+len(patterns))
+	copy(rules, m.rules)
+	for _, p := range patterns {
+		r := compile(base, p)
+		if r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return &Matcher{rules: rules}
+}
+
+// Match reports whether the slash separated path p, relative to the root of
+// the matcher, is ignored.
+func (m *Matcher) Match(p string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := p
+		if r.base != "" {
+			if !strings.HasPrefix(p, r.base+"/") {
+				continue
+			}
+			rel = p[len(r.base)+1:]
+		}
+		if r.re.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// ReadFile reads the patterns from an ignore file, skipping blank lines and
+// comments.
+func ReadFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// files saved on windows end their lines with \r\n
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+func compile(base, pattern string) *rule {
+	pattern = trimTrailingSpace(pattern)
+	if pattern == "" {
+		return nil
+	}
+
+	r := &rule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+
+	// A pattern with a slash anywhere but the end is relative to base,
+	// otherwise it can match at any depth.
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	re := &strings.Builder{}
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(.*/)?")
+	}
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "**" {
+			if last {
+				re.WriteString(".*")
+			} else {
+				re.WriteString("(.*/)?")
+			}
+			continue
+		}
+		re.WriteString(segmentRegex(segment))
+		if !last {
+			re.WriteString("/")
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		// an unterminated character class or similar, match it literally
+		compiled = regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+	}
+	r.re = compiled
+	return r
+}
+
+// segmentRegex converts a single path segment of a glob into a regex.
+func segmentRegex(segment string) string {
+	re := &strings.Builder{}
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch c {
+		case '*':
+			re.WriteString("[^/]*")
+			for i+1 < len(segment) && segment[i+1] == '*' {
+				i++
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(segment) {
+				i++
+				re.WriteString(regexp.QuoteMeta(segment[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(segment[i+1:], ']')
+			if end == -1 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := segment[i+1 : i+1+end]
+			if end == 0 {
+				// []...] includes a literal ]
+				next := strings.IndexByte(segment[i+2:], ']')
+				if next == -1 {
+					re.WriteString(`\[`)
+					continue
+				}
+				end = next + 1
+				class = segment[i+1 : i+1+end]
+			}
+			re.WriteString("[")
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				re.WriteString("^")
+				class = class[1:]
+			}
+			re.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			re.WriteString("]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(segment[i : i+1]))
+		}
+	}
+	return re.String()
+}
+
+func trimTrailingSpace(s string) string {
+	for strings.HasSuffix(s, " ") && !strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-1]
+	}
+	if strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-2] + " "
+	}
+	return s
+}
+
+// Join joins a directory relative to the root of a matcher with a name.
+func Join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return path.Join(dir, name)
+}
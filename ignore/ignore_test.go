@@ -0,0 +1,85 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		ignored  bool
+	}{
+		{"name anywhere", []string{"node_modules"}, "a/b/node_modules", true, true},
+		{"name at root", []string{"node_modules"}, "node_modules", true, true},
+		{"partial name", []string{"node_modules"}, "a/not_node_modules", true, false},
+		{"dot is literal", []string{"a.txt"}, "abtxt", false, false},
+		{"plus is literal", []string{"c++"}, "c++", true, true},
+		{"parens are literal", []string{"(copy)"}, "x/(copy)", false, true},
+		{"star", []string{"*.log"}, "logs/x.log", false, true},
+		{"star does not cross slash", []string{"a/*.log"}, "a/b/x.log", false, false},
+		{"leading slash anchors", []string{"/build"}, "src/build", true, false},
+		{"leading slash matches root", []string{"/build"}, "build", true, true},
+		{"middle slash anchors", []string{"a/b"}, "x/a/b", false, false},
+		{"double star prefix", []string{"**/cache"}, "a/b/cache", true, true},
+		{"double star middle", []string{"a/**/b"}, "a/b", false, true},
+		{"double star middle deep", []string{"a/**/b"}, "a/x/y/b", false, true},
+		{"double star suffix", []string{"a/**"}, "a/x/y", false, true},
+		{"dir only matches dir", []string{"tmp/"}, "x/tmp", true, true},
+		{"dir only skips file", []string{"tmp/"}, "x/tmp", false, false},
+		{"character class", []string{"file[0-9].txt"}, "file5.txt", false, true},
+		{"negated character class", []string{"file[!0-9].txt"}, "file5.txt", false, false},
+		{"question mark", []string{"?.txt"}, "a.txt", false, true},
+		{"negation", []string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{"negation order", []string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{"escaped bang", []string{`\!important`}, "!important", false, true},
+		{"escaped star", []string{`\*`}, "a", false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := New(tc.patterns)
+			assert.Equal(t, tc.ignored, m.Match(tc.path, tc.isDir))
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	m := New([]string{"*.log"}).Add("sub", []string{"!keep.log", "/local"})
+
+	assert.True(t, m.Match("other/keep.log", false))
+	assert.False(t, m.Match("sub/keep.log", false))
+	assert.False(t, m.Match("sub/deeper/keep.log", false))
+	assert.True(t, m.Match("sub/local", false))
+	assert.False(t, m.Match("local", false))
+	assert.False(t, m.Match("sub/deeper/local", false))
+}
+
+func TestReadFile(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     string
+		patterns []string
+	}{
+		{"lf", "*.log\n# comment\n/build\n", []string{"*.log", "/build"}},
+		{"crlf", "*.log\r\n# comment\r\n/build\r\n", []string{"*.log", "/build"}},
+		{"no trailing newline", "*.log\r\n/build", []string{"*.log", "/build"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), ".backupignore")
+			require.NoError(t, os.WriteFile(file, []byte(tc.data), 0644))
+
+			patterns, err := ReadFile(file)
+			require.NoError(t, err)
+			assert.Equal(t, tc.patterns, patterns)
+			assert.True(t, New(patterns).Match("x/a.log", false))
+		})
+	}
+}
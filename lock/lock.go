@@ -0,0 +1,235 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abibby/backup/backend"
+)
+
+const (
+	dir = "locks"
+	// ttl is how long a lock lasts without being refreshed.
+	ttl = 5 * time.Minute
+)
+
+var ErrLocked = errors.New("repository is locked")
+
+// A Lock is stored in a backend while a process is using it.
+type Lock struct {
+	ID      string `json:"id"`
+	Host    string `json:"host"`
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	// Exclusive locks can't be held at the same time as any other lock.
+	// Shared locks can be held alongside other shared locks.
+	Exclusive bool      `json:"exclusive"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+}
+
+func (l *Lock) String() string {
+	return fmt.Sprintf("%s (%s, pid %d on %s since %s)", l.Command, l.Mode(), l.PID, l.Host, l.Created.Local().Format(time.DateTime))
+}
+
+func (l *Lock) Mode() string {
+	if l.Exclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// Stale reports whether the process holding the lock has stopped, either
+// because the lock has expired or because its process is no longer running on
+// this host.
+func (l *Lock) Stale() bool {
+	if time.Now().After(l.Expires) {
+		return true
+	}
+	host, _ := os.Hostname()
+	return l.Host == host && !processRunning(l.PID)
+}
+
+func key(id string) string {
+	return path.Join(dir, id+".lock")
+}
+
+// List returns the locks in b in the order they were created.
+func List(b backend.Backend) ([]*Lock, error) {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return []*Lock{}, nil
+	}
+	names, err := store.ListObjects(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+
+	locks := []*Lock{}
+	for _, name := range names {
+		id, ok := strings.CutSuffix(name, ".lock")
+		if !ok {
+			continue
+		}
+		data, err := store.GetObject(key(id))
+		if errors.Is(err, os.ErrNotExist) {
+			// released since it was listed
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read lock %s: %w", id, err)
+		}
+		l := &Lock{}
+		err = json.Unmarshal(data, l)
+		if err != nil {
+			slog.Warn("invalid lock", "backend", b.URI(), "lock", id, "err", err)
+			continue
+		}
+		locks = append(locks, l)
+	}
+	slices.SortFunc(locks, func(a, b *Lock) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return locks, nil
+}
+
+// Remove deletes a lock from b.
+func Remove(b backend.Backend, l *Lock) error {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return nil
+	}
+	return store.DeleteObject(key(l.ID))
+}
+
+// A Held lock is refreshed in the background until it is released.
+type Held struct {
+	Lock
+	store backend.ObjectStore
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Acquire locks b for command. It returns an error wrapping ErrLocked if
+// another process holds a lock that isn't stale and conflicts with it.
+// Backends that can't store locks are not locked.
+func Acquire(b backend.Backend, command string, exclusive bool) (*Held, error) {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return nil, nil
+	}
+
+	err := checkLocks(b, "", exclusive)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	h := &Held{
+		Lock: Lock{
+			ID:        hex.EncodeToString(id),
+			Host:      host,
+			PID:       os.Getpid(),
+			Command:   command,
+			Exclusive: exclusive,
+			Created:   now,
+			Expires:   now.Add(ttl),
+		},
+		store: store,
+		stop:  make(chan struct{}),
+	}
+	err = h.put()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write lock: %w", err)
+	}
+
+	// another process may have taken a lock at the same time, the oldest lock
+	// wins
+	err = checkLocks(b, h.ID, exclusive)
+	if err != nil {
+		h.store.DeleteObject(key(h.ID))
+		return nil, err
+	}
+
+	h.wg.Add(1)
+	go h.refresh()
+	return h, nil
+}
+
+// checkLocks returns an error if b has a lock that isn't stale, conflicts
+// with a new lock and was created before the lock with the given id.
+func checkLocks(b backend.Backend, id string, exclusive bool) error {
+	locks, err := List(b)
+	if err != nil {
+		return err
+	}
+	for _, l := range locks {
+		if l.ID == id {
+			return nil
+		}
+		if !exclusive && !l.Exclusive {
+			continue
+		}
+		if l.Stale() {
+			slog.Warn("ignoring stale lock", "backend", b.URI(), "lock", l)
+			continue
+		}
+		return fmt.Errorf("%w by %s", ErrLocked, l)
+	}
+	return nil
+}
+
+func (h *Held) put() error {
+	data, err := json.Marshal(h.Lock)
+	if err != nil {
+		return err
+	}
+	return h.store.PutObject(key(h.ID), data)
+}
+
+func (h *Held) refresh() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(ttl / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.Expires = time.Now().Add(ttl)
+			err := h.put()
+			if err != nil {
+				slog.Error("failed to refresh lock", "lock", h.ID, "err", err)
+			}
+		}
+	}
+}
+
+// Release stops refreshing the lock and removes it. It is safe to call on a
+// nil lock.
+func (h *Held) Release() error {
+	if h == nil {
+		return nil
+	}
+	close(h.stop)
+	h.wg.Wait()
+	return h.store.DeleteObject(key(h.ID))
+}
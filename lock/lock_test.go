@@ -0,0 +1,64 @@
+package lock
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	b := backend.NewFile(t.TempDir())
+
+	h1, err := Acquire(b, "backup", false)
+	require.NoError(t, err)
+	h2, err := Acquire(b, "backup", false)
+	require.NoError(t, err)
+
+	_, err = Acquire(b, "reconcile", true)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, h1.Release())
+	require.NoError(t, h2.Release())
+
+	h, err := Acquire(b, "reconcile", true)
+	require.NoError(t, err)
+
+	_, err = Acquire(b, "backup", false)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, h.Release())
+
+	locks, err := List(b)
+	require.NoError(t, err)
+	assert.Empty(t, locks)
+}
+
+func TestAcquireStale(t *testing.T) {
+	b := backend.NewFile(t.TempDir())
+	store, _ := backend.Objects(b)
+
+	stale := &Lock{
+		ID:        "stale",
+		Host:      "other-host",
+		PID:       1,
+		Exclusive: true,
+		Created:   time.Now().Add(-time.Hour),
+		Expires:   time.Now().Add(-time.Minute),
+	}
+	data, err := json.Marshal(stale)
+	require.NoError(t, err)
+	require.NoError(t, store.PutObject(key(stale.ID), data))
+
+	h, err := Acquire(b, "backup", false)
+	require.NoError(t, err)
+	require.NoError(t, h.Release())
+
+	locks, err := List(b)
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	assert.True(t, locks[0].Stale())
+}
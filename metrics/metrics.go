@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abibby/backup/backup"
+)
+
+type backendMetrics struct {
+	uploaded     int
+	failed       int
+	bytesWritten int64
+	queueDepth   int
+}
+
+var (
+	mtx          sync.Mutex
+	lastRun      time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	lastFailed   bool
+	runs         = map[string]int{}
+	filesScanned int
+	bytesRead    int64
+	backends     = map[string]*backendMetrics{}
+)
+
+func getBackend(uri string) *backendMetrics {
+	b, ok := backends[uri]
+	if !ok {
+		b = &backendMetrics{}
+		backends[uri] = b
+	}
+	return b
+}
+
+// RecordRun adds the results of a backup run to the metrics.
+func RecordRun(r *backup.Report, err error) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	lastRun = r.Start
+	lastDuration = r.Duration
+	lastFailed = err != nil || r.Failed() > 0
+	if !lastFailed {
+		lastSuccess = r.Start
+		runs["success"]++
+	} else {
+		runs["failure"]++
+	}
+
+	filesScanned += r.Scanned
+	bytesRead += r.BytesRead
+	for uri, br := range r.Backends {
+		b := getBackend(uri)
+		b.uploaded += br.Uploaded
+		b.failed += br.Failed
+		b.bytesWritten += br.BytesWritten
+	}
+}
+
+// SetLastSuccess sets the time of the last successful run, e.g. from the
+// database when the process starts.
+func SetLastSuccess(t time.Time) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	if t.After(lastSuccess) {
+		lastSuccess = t
+	}
+}
+
+func SetQueueDepth(uri string, depth int) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	getBackend(uri).queueDepth = depth
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Write(w)
+	})
+}
+
+// Write writes all metrics in the Prometheus text exposition format.
+func Write(w io.Writer) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	gauge(w, "backup_last_run_timestamp_seconds", "Start time of the last backup run.", unix(lastRun))
+	gauge(w, "backup_last_success_timestamp_seconds", "Start time of the last successful backup run.", unix(lastSuccess))
+	gauge(w, "backup_last_run_duration_seconds", "Duration of the last backup run.", lastDuration.Seconds())
+	gauge(w, "backup_last_run_failed", "1 if the last backup run failed.", boolValue(lastFailed))
+
+	header(w, "backup_runs_total", "counter", "Number of backup runs by result.")
+	for _, result := range []string{"success", "failure"} {
+		fmt.Fprintf(w, "backup_runs_total{result=%q} %d\n", result, runs[result])
+	}
+
+	header(w, "backup_files_scanned_total", "counter", "Number of files found while scanning.")
+	fmt.Fprintf(w, "backup_files_scanned_total %d\n", filesScanned)
+
+	header(w, "backup_bytes_read_total", "counter", "Bytes read from disk.")
+	fmt.Fprintf(w, "backup_bytes_read_total %d\n", bytesRead)
+
+	uris := make([]string, 0, len(backends))
+	for uri := range backends {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	perBackend := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(b *backendMetrics) int64
+	}{
+		{"backup_files_uploaded_total", "counter", "Number of files uploaded.", func(b *backendMetrics) int64 { return int64(b.uploaded) }},
+		{"backup_files_failed_total", "counter", "Number of files that failed to upload.", func(b *backendMetrics) int64 { return int64(b.failed) }},
+		{"backup_bytes_written_total", "counter", "Bytes stored by the backend for successfully uploaded files.", func(b *backendMetrics) int64 { return b.bytesWritten }},
+		{"backup_queue_depth", "gauge", "Number of files queued while the backend was unreachable.", func(b *backendMetrics) int64 { return int64(b.queueDepth) }},
+	}
+	for _, m := range perBackend {
+		header(w, m.name, m.kind, m.help)
+		for _, uri := range uris {
+			fmt.Fprintf(w, "%s{backend=\"%s\"} %d\n", m.name, escape(uri), m.value(backends[uri]))
+		}
+	}
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func gauge(w io.Writer, name, help string, value float64) {
+	header(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+func unix(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Command runs a shell command with the event as JSON on stdin and the event
+// name in the BACKUP_EVENT environment variable.
+type Command string
+
+func (c Command) Notify(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", string(c))
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"BACKUP_EVENT="+e.Event,
+		"BACKUP_HOST="+e.Host,
+	)
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("notify command %q failed: %w", string(c), err)
+	}
+	return nil
+}
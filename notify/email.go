@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abibby/backup/bytesize"
+)
+
+type Email struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+func (m *Email) Notify(e *Event) error {
+	port := m.Port
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", subject(e))
+	fmt.Fprintf(msg, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	writeBody(msg, e)
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(port))
+	return smtp.SendMail(addr, auth, m.From, m.To, msg.Bytes())
+}
+
+func subject(e *Event) string {
+	switch e.Event {
+	case EventFailure:
+		return fmt.Sprintf("Backup failed on %s", e.Host)
+	case EventRecovered:
+		return fmt.Sprintf("Backup recovered on %s", e.Host)
+	case EventStale:
+		return fmt.Sprintf("Backup on %s is stale", e.Host)
+	}
+	return fmt.Sprintf("Backup %s on %s", e.Event, e.Host)
+}
+
+func writeBody(w *bytes.Buffer, e *Event) {
+	fmt.Fprintf(w, "%s\r\n\r\n", subject(e))
+	if e.Error != "" {
+		fmt.Fprintf(w, "Error: %s\r\n", e.Error)
+	}
+	if e.LastSuccess.IsZero() {
+		fmt.Fprintf(w, "Last success: never\r\n")
+	} else {
+		fmt.Fprintf(w, "Last success: %s\r\n", e.LastSuccess.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Started: %s\r\n", e.Run.Start.Format(time.RFC3339))
+	fmt.Fprintf(w, "Duration: %s\r\n", time.Duration(e.Run.Duration*float64(time.Second)).Truncate(time.Second))
+	fmt.Fprintf(w, "Scanned: %d\r\n", e.Run.Scanned)
+	fmt.Fprintf(w, "Uploaded: %d\r\n", e.Run.Uploaded)
+	fmt.Fprintf(w, "Failed: %d\r\n", e.Run.Failed)
+	fmt.Fprintf(w, "Written: %s\r\n", bytesize.Format(e.Run.BytesWritten))
+	if len(e.Run.Errors) > 0 {
+		fmt.Fprintf(w, "\r\nErrors:\r\n")
+		for _, err := range e.Run.Errors {
+			fmt.Fprintf(w, "  %s\r\n", err)
+		}
+	}
+}
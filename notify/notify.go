@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/abibby/backup/backup"
+	"github.com/abibby/backup/database"
+)
+
+const (
+	EventFailure   = "failure"
+	EventRecovered = "recovered"
+	EventStale     = "stale"
+)
+
+type Event struct {
+	Event       string    `json:"event"`
+	Host        string    `json:"host"`
+	Time        time.Time `json:"time"`
+	LastSuccess time.Time `json:"last_success"`
+	Error       string    `json:"error,omitempty"`
+	Run         *Summary  `json:"run"`
+}
+
+type Summary struct {
+	Start        time.Time `json:"start"`
+	Duration     float64   `json:"duration_seconds"`
+	Scanned      int       `json:"scanned"`
+	Uploaded     int       `json:"uploaded"`
+	Failed       int       `json:"failed"`
+	BytesWritten int64     `json:"bytes_written"`
+	Errors       []string  `json:"errors"`
+}
+
+type Notifier interface {
+	Notify(e *Event) error
+}
+
+type Config struct {
+	StaleAfter time.Duration `mapstructure:"stale-after"`
+	Webhooks   []*Webhook    `mapstructure:"webhooks"`
+	Email      *Email        `mapstructure:"email"`
+	Commands   []string      `mapstructure:"commands"`
+}
+
+func (c *Config) Notifiers() []Notifier {
+	notifiers := []Notifier{}
+	for _, w := range c.Webhooks {
+		notifiers = append(notifiers, w)
+	}
+	if c.Email != nil && c.Email.Host != "" {
+		notifiers = append(notifiers, c.Email)
+	}
+	for _, command := range c.Commands {
+		notifiers = append(notifiers, Command(command))
+	}
+	return notifiers
+}
+
+// Update records the result of a run in state and returns the events that
+// should be sent because of it. A run fails if it returned an error or any
+// file couldn't be backed up.
+func Update(state *database.RunState, report *backup.Report, runErr error, staleAfter time.Duration) []string {
+	events := []string{}
+	previouslyFailed := state.Failed
+
+	if state.FirstRun.IsZero() {
+		state.FirstRun = report.Start
+	}
+	state.LastRun = report.Start
+	state.Duration = report.Duration
+	state.Failed = runErr != nil || report.Failed() > 0
+	state.Error = ""
+
+	if state.Failed {
+		if runErr != nil {
+			state.Error = runErr.Error()
+		} else {
+			state.Error = fmt.Sprintf("%d files failed to back up", report.Failed())
+		}
+		events = append(events, EventFailure)
+	} else {
+		state.LastSuccess = report.Start
+		state.StaleNotified = false
+		if previouslyFailed {
+			events = append(events, EventRecovered)
+		}
+	}
+
+	if Stale(state, report.Start, staleAfter) {
+		state.StaleNotified = true
+		events = append(events, EventStale)
+	}
+
+	return events
+}
+
+// Stale reports whether a stale notification should be sent at now because
+// there hasn't been a successful run for longer than staleAfter. It is false
+// once the notification has been sent.
+func Stale(state *database.RunState, now time.Time, staleAfter time.Duration) bool {
+	if staleAfter <= 0 || state.StaleNotified {
+		return false
+	}
+	since := state.LastSuccess
+	if since.IsZero() {
+		since = state.FirstRun
+	}
+	return !since.IsZero() && now.Sub(since) > staleAfter
+}
+
+func NewEvent(event string, state *database.RunState, report *backup.Report) *Event {
+	host, _ := os.Hostname()
+	errs := report.Errors
+	if errs == nil {
+		errs = []string{}
+	}
+	return &Event{
+		Event:       event,
+		Host:        host,
+		Time:        time.Now(),
+		LastSuccess: state.LastSuccess,
+		Error:       state.Error,
+		Run: &Summary{
+			Start:        report.Start,
+			Duration:     report.Duration.Seconds(),
+			Scanned:      report.Scanned,
+			Uploaded:     report.Uploaded(),
+			Failed:       report.Failed(),
+			BytesWritten: report.BytesWritten(),
+			Errors:       errs,
+		},
+	}
+}
+
+// Send delivers e to every notifier, logging any that fail.
+func Send(notifiers []Notifier, e *Event) {
+	for _, n := range notifiers {
+		err := n.Notify(e)
+		if err != nil {
+			slog.Error("failed to send notification", "event", e.Event, "err", err)
+		}
+	}
+}
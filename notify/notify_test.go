@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backup"
+	"github.com/abibby/backup/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	start := time.Unix(1000, 0)
+	state := &database.RunState{}
+	report := &backup.Report{Start: start, Backends: map[string]*backup.BackendReport{}}
+	assert.Empty(t, Update(state, report, nil, time.Hour))
+	assert.Equal(t, start, state.LastSuccess)
+
+	// a run where files failed is a failure even though it returned no error
+	report = &backup.Report{
+		Start:    start.Add(time.Minute),
+		Backends: map[string]*backup.BackendReport{"file:///a": {Failed: 2}},
+	}
+	assert.Equal(t, []string{EventFailure}, Update(state, report, nil, time.Hour))
+	assert.True(t, state.Failed)
+	assert.Equal(t, "2 files failed to back up", state.Error)
+	assert.Equal(t, start, state.LastSuccess)
+
+	// stale is sent once
+	assert.False(t, Stale(state, start.Add(time.Minute), time.Hour))
+	assert.True(t, Stale(state, start.Add(2*time.Hour), time.Hour))
+	state.StaleNotified = true
+	assert.False(t, Stale(state, start.Add(3*time.Hour), time.Hour))
+}
@@ -0,0 +1,214 @@
+package queue
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+)
+
+var ErrOffline = errors.New("backend is offline")
+
+// Backend stands in for a backend that could not be loaded. Files written to
+// it are added to the queue in the local database and optionally copied to a
+// staging directory until the real backend can be reached again.
+type Backend struct {
+	db      *database.DB
+	uri     string
+	staging string
+}
+
+func New(db *database.DB, uri, staging string) backend.Backend {
+	return &Backend{
+		db:      db,
+		uri:     uri,
+		staging: staging,
+	}
+}
+
+func (b *Backend) URI() string {
+	return b.uri
+}
+
+// Write queues the file at p. It is only copied if staging is set, otherwise
+// it is read from p when the queue is flushed.
+func (b *Backend) Write(p string, t time.Time, data io.Reader) error {
+	f := &database.QueuedFile{
+		Path:     p,
+		Modified: t,
+	}
+	if b.staging != "" {
+		staged, err := b.stage(b.staging, p, t, data)
+		if err != nil {
+			return fmt.Errorf("failed to stage file: %w", err)
+		}
+		f.Staged = staged
+	}
+	return b.enqueue(f)
+}
+
+// WriteVirtual queues a file that doesn't exist on disk, such as a stream.
+// There is nothing to read when the queue is flushed so it is always staged,
+// in the user's cache directory if staging isn't set.
+func (b *Backend) WriteVirtual(p string, t time.Time, data io.Reader) error {
+	dir := b.staging
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("no staging directory for %s: %w", p, err)
+		}
+		dir = path.Join(cache, "backup", "staging")
+	}
+	staged, err := b.stage(dir, p, t, data)
+	if err != nil {
+		return fmt.Errorf("failed to stage file: %w", err)
+	}
+	return b.enqueue(&database.QueuedFile{
+		Path:     p,
+		Modified: t,
+		Staged:   staged,
+		Virtual:  true,
+	})
+}
+
+func (b *Backend) enqueue(f *database.QueuedFile) error {
+	err := b.db.Enqueue(b.uri, f)
+	if err != nil && f.Staged != "" {
+		os.Remove(f.Staged)
+	}
+	return err
+}
+
+func (b *Backend) List(p string) ([]backend.File, error) {
+	return nil, ErrOffline
+}
+
+func (b *Backend) Read(p string) (backend.File, error) {
+	return nil, ErrOffline
+}
+
+func (b *Backend) stage(dir, p string, t time.Time, data io.Reader) (string, error) {
+	sum := sha1.Sum([]byte(b.uri))
+	staged := path.Join(dir, hex.EncodeToString(sum[:6]), fmt.Sprintf("%s-%d", p, t.Unix()))
+
+	err := os.MkdirAll(path.Dir(staged), 0700)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(staged, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		// a partial copy would be flushed as if it were the whole file
+		os.Remove(staged)
+		return "", err
+	}
+	return staged, nil
+}
+
+// Flush writes every file queued for b while it was offline and records the
+// ones that were written as backed up. Files that fail stay queued and are
+// recorded as failed so the rest of the queue isn't held back by them.
+func Flush(db *database.DB, b backend.Backend) error {
+	files, err := db.Queued(b.URI())
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		slog.Info("flushing queued files", "backend", b.URI(), "files", len(files))
+	}
+
+	var errs []error
+	for _, f := range files {
+		err = flushQueued(db, b, f)
+		if err != nil {
+			err = fmt.Errorf("failed to flush %s: %w", f.Path, err)
+			errs = append(errs, err)
+			setErr := db.SetFailed(b, f.Path, err)
+			if setErr != nil {
+				slog.Error("failed to record failure", "file", f.Path, "err", setErr)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func flushQueued(db *database.DB, b backend.Backend, f *database.QueuedFile) error {
+	written, err := flushFile(b, f)
+	if err != nil {
+		return err
+	}
+	if written && f.Record != nil {
+		err = db.SetFile(b, f.Path, f.Record)
+	} else {
+		// an earlier flush may have failed
+		err = db.ClearFailed(b, f.Path)
+	}
+	if err != nil {
+		return err
+	}
+	err = db.Dequeue(b.URI(), f)
+	if err != nil {
+		return err
+	}
+	if f.Staged != "" {
+		err = os.Remove(f.Staged)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("failed to remove staged file", "file", f.Staged, "err", err)
+		}
+	}
+	return nil
+}
+
+// flushFile writes f to b and reports whether it was written. Files that
+// have changed or are gone since they were queued are skipped.
+func flushFile(b backend.Backend, f *database.QueuedFile) (bool, error) {
+	if f.Staged != "" {
+		file, err := os.Open(f.Staged)
+		if err != nil {
+			return false, err
+		}
+		defer file.Close()
+		return true, b.Write(f.Path, f.Modified, file)
+	}
+	if f.Virtual {
+		return false, fmt.Errorf("the staged copy is missing")
+	}
+
+	file, err := os.Open(f.Path)
+	if os.IsNotExist(err) {
+		slog.Warn("queued file no longer exists", "file", f.Path)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Unix() != f.Modified.Unix() {
+		// The file has changed since it was queued. The newer version will be
+		// picked up by the next scan.
+		slog.Debug("queued file has changed, skipping", "file", f.Path)
+		return false, nil
+	}
+	return true, b.Write(f.Path, f.Modified, file)
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func readLatest(t *testing.T, b backend.Backend, p string) string {
+	t.Helper()
+	f, err := b.Read(p)
+	require.NoError(t, err)
+	v, ok := backend.VersionAt(f, time.Time{})
+	require.True(t, ok)
+	r, err := f.Data(v)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestFlushRecordsFiles(t *testing.T) {
+	db := openDB(t)
+	b := backend.NewFile(t.TempDir())
+	p := filepath.Join(t.TempDir(), "a.txt")
+	modified := time.Unix(1000, 0)
+	require.NoError(t, os.WriteFile(p, []byte("a"), 0644))
+	require.NoError(t, os.Chtimes(p, modified, modified))
+
+	q := New(db, b.URI(), "")
+	require.NoError(t, q.Write(p, modified, strings.NewReader("a")))
+	require.NoError(t, db.SetQueuedRecord(b.URI(), p, &database.FileRecord{Modified: modified, Size: 1}))
+
+	// the file isn't recorded until it reaches the backend
+	f, err := db.GetFile(b, p)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	require.NoError(t, Flush(db, b))
+	f, err = db.GetFile(b, p)
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	assert.Equal(t, int64(1), f.Size)
+	assert.Equal(t, "a", readLatest(t, b, p))
+	n, err := db.QueueLength(b.URI())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFlushSkipsChangedFiles(t *testing.T) {
+	db := openDB(t)
+	b := backend.NewFile(t.TempDir())
+	p := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(p, []byte("a"), 0644))
+
+	modified := time.Unix(1000, 0)
+	q := New(db, b.URI(), "")
+	require.NoError(t, q.Write(p, modified, strings.NewReader("a")))
+	require.NoError(t, db.SetQueuedRecord(b.URI(), p, &database.FileRecord{Modified: modified}))
+
+	require.NoError(t, Flush(db, b))
+	f, err := db.GetFile(b, p)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	n, err := db.QueueLength(b.URI())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFlushVirtualFiles(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	db := openDB(t)
+	b := backend.NewFile(t.TempDir())
+	// a file on disk at the stream's path must not be uploaded in its place
+	p := filepath.Join(t.TempDir(), "dump.sql")
+	require.NoError(t, os.WriteFile(p, []byte("disk"), 0644))
+
+	q := New(db, b.URI(), "").(*Backend)
+	require.NoError(t, q.WriteVirtual(p, time.Unix(1000, 0), strings.NewReader("stream")))
+
+	require.NoError(t, Flush(db, b))
+	assert.Equal(t, "stream", readLatest(t, b, p))
+}
+
+func TestFlushContinuesAfterFailures(t *testing.T) {
+	db := openDB(t)
+	b := backend.NewFile(t.TempDir())
+	q := New(db, b.URI(), t.TempDir())
+	modified := time.Unix(1000, 0)
+	require.NoError(t, q.Write("/a.txt", modified, strings.NewReader("a")))
+	require.NoError(t, q.Write("/b.txt", modified, strings.NewReader("b")))
+
+	queued, err := db.Queued(b.URI())
+	require.NoError(t, err)
+	require.Len(t, queued, 2)
+	require.Equal(t, "/a.txt", queued[0].Path)
+	require.NoError(t, os.Remove(queued[0].Staged))
+
+	assert.Error(t, Flush(db, b))
+	assert.Equal(t, "b", readLatest(t, b, "/b.txt"))
+
+	queued, err = db.Queued(b.URI())
+	require.NoError(t, err)
+	require.Len(t, queued, 1)
+	assert.Equal(t, "/a.txt", queued[0].Path)
+	failed, err := db.Failed(b.URI())
+	require.NoError(t, err)
+	assert.Contains(t, failed, "/a.txt")
+}
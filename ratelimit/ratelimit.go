@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abibby/backup/bytesize"
+)
+
+// A Rate returns the number of bytes per second allowed at a given time. A
+// rate of 0 or less is unlimited.
+type Rate func(t time.Time) int64
+
+func Fixed(bytesPerSecond int64) Rate {
+	return func(t time.Time) int64 {
+		return bytesPerSecond
+	}
+}
+
+// ParseRate parses a bandwidth such as "5MiB/s". An empty string, "0" or
+// "unlimited" all mean no limit.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "unlimited") {
+		return 0, nil
+	}
+	n, err := bytesize.Parse(strings.TrimSuffix(s, "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q: %w", s, err)
+	}
+	return n, nil
+}
+
+type Limiter struct {
+	rate   Rate
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func New(rate Rate) *Limiter {
+	return &Limiter{
+		rate: rate,
+	}
+}
+
+// WaitN blocks until n more bytes can be transferred. The limiter allows
+// bursts of up to one second worth of data.
+func (l *Limiter) WaitN(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := time.Now()
+	rate := l.rate(now)
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = time.Time{}
+		return
+	}
+
+	burst := float64(rate)
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / float64(rate) * float64(time.Second)))
+	}
+}
+
+const chunkSize = 32 * 1024
+
+type reader struct {
+	r        io.Reader
+	limiters []*Limiter
+}
+
+// NewReader wraps r so that reads from it are throttled by all of the given
+// limiters.
+func NewReader(r io.Reader, limiters ...*Limiter) io.Reader {
+	if len(limiters) == 0 {
+		return r
+	}
+	return &reader{
+		r:        r,
+		limiters: limiters,
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		l.WaitN(n)
+	}
+	return n, err
+}
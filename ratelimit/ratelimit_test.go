@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	n, err := ParseRate("5MiB/s")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5*1024*1024), n)
+
+	n, err = ParseRate("unlimited")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	_, err = ParseRate("fast")
+	assert.Error(t, err)
+}
+
+func TestSchedule(t *testing.T) {
+	s := &Schedule{
+		Default: 100,
+		Windows: []Window{
+			{From: 9 * time.Hour, To: 17 * time.Hour, Limit: 10},
+			{From: 22 * time.Hour, To: 6 * time.Hour, Limit: 0},
+		},
+	}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	assert.Equal(t, int64(10), s.Rate(at(9, 0)))
+	assert.Equal(t, int64(10), s.Rate(at(16, 59)))
+	assert.Equal(t, int64(100), s.Rate(at(17, 0)))
+	assert.Equal(t, int64(0), s.Rate(at(23, 30)))
+	assert.Equal(t, int64(0), s.Rate(at(2, 0)))
+	assert.Equal(t, int64(100), s.Rate(at(7, 0)))
+}
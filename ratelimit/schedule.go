@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+type Window struct {
+	From  time.Duration
+	To    time.Duration
+	Limit int64
+}
+
+// A Schedule applies different limits depending on the time of day. The first
+// window containing the current time wins, outside of every window Default is
+// used.
+type Schedule struct {
+	Default int64
+	Windows []Window
+}
+
+func (s *Schedule) Rate(t time.Time) int64 {
+	y, m, d := t.Date()
+	sinceMidnight := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	for _, w := range s.Windows {
+		if w.contains(sinceMidnight) {
+			return w.Limit
+		}
+	}
+	return s.Default
+}
+
+func (w Window) contains(d time.Duration) bool {
+	if w.From <= w.To {
+		return d >= w.From && d < w.To
+	}
+	// the window wraps around midnight e.g. 22:00 - 06:00
+	return d >= w.From || d < w.To
+}
+
+// ParseTimeOfDay parses a 24 hour time such as "09:00" into the duration since
+// midnight.
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
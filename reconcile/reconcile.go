@@ -1,42 +1,221 @@
 package reconcile
 
 import (
-	"path/filepath"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/abibby/backup/backend"
 	"github.com/abibby/backup/database"
 	"go.etcd.io/bbolt"
 )
 
-func Reconcile(db *database.DB, b backend.Backend) error {
-	return db.Update(b, func(tx *bbolt.Tx, bucketName []byte) error {
-		err := tx.DeleteBucket(bucketName)
+// DefaultBatchSize is the number of files compared in each database
+// transaction if Options.BatchSize isn't set.
+const DefaultBatchSize = 1000
+
+var (
+	progressBucket = []byte("reconcile")
+	seenBucket     = []byte("seen")
+	cursorKey      = []byte("cursor")
+	modeKey        = []byte("mode")
+)
+
+type Kind string
+
+const (
+	// MissingLocally files are in the backend but not in the database.
+	MissingLocally Kind = "missing-locally"
+	// MissingRemotely files are in the database but their latest version
+	// isn't in the backend.
+	MissingRemotely Kind = "missing-remotely"
+	// NewerRemotely files have a newer version in the backend than the one
+	// in the database.
+	NewerRemotely Kind = "newer-remotely"
+)
+
+// A Difference is a file the database and the backend disagree on.
+type Difference struct {
+	Kind Kind
+	Path string
+	// Local and Remote are the latest versions in the database and the
+	// backend. They are zero if the file is missing.
+	Local  time.Time
+	Remote time.Time
+}
+
+type Options struct {
+	// DryRun reports the differences without changing the database.
+	DryRun    bool
+	BatchSize int
+	// Restart discards the progress of an interrupted reconcile instead of
+	// resuming it.
+	Restart bool
+	// Difference is called with each difference as it is found.
+	Difference func(*Difference)
+}
+
+type Result struct {
+	Checked         int
+	MissingLocally  int
+	MissingRemotely int
+	NewerRemotely   int
+}
+
+type remoteFile struct {
+	path     string
+	versions []time.Time
+}
+
+type reconciler struct {
+	db        *database.DB
+	b         backend.Backend
+	o         *Options
+	batchSize int
+	// cursor is the last file that was compared. Files up to it in walk order
+	// are skipped when a reconcile is resumed.
+	cursor  string
+	pending []*remoteFile
+	result  *Result
+}
+
+// Reconcile compares the files recorded in the database for b with the files
+// in b and, unless o.DryRun is set, updates the database to match. The backend
+// is walked in batches and the progress is saved after each one so an
+// interrupted reconcile continues where it stopped the next time it is run.
+func Reconcile(db *database.DB, b backend.Backend, o *Options) (*Result, error) {
+	r := &reconciler{
+		db:        db,
+		b:         b,
+		o:         o,
+		batchSize: o.BatchSize,
+		result:    &Result{},
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = DefaultBatchSize
+	}
+
+	err := r.start()
+	if err != nil {
+		return nil, err
+	}
+	err = r.walk("/")
+	if err == nil {
+		err = r.flush()
+	}
+	if err != nil {
+		if r.cursor != "" {
+			return r.result, fmt.Errorf("reconcile stopped after %s, run it again to resume: %w", r.cursor, err)
+		}
+		return r.result, err
+	}
+	err = r.removeMissing()
+	if err != nil {
+		return r.result, err
+	}
+	return r.result, r.finish()
+}
+
+func (r *reconciler) mode() []byte {
+	if r.o.DryRun {
+		return []byte("dry-run")
+	}
+	return []byte("update")
+}
+
+// start loads the progress of an earlier reconcile of the same mode or starts
+// a new one.
+func (r *reconciler) start() error {
+	return r.db.Update(r.b, func(tx *bbolt.Tx, id []byte) error {
+		if !r.o.DryRun {
+			_, err := database.CreateFilesBucket(tx, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		all, err := tx.CreateBucketIfNotExists(progressBucket)
 		if err != nil {
 			return err
 		}
+		if progress := all.Bucket(id); progress != nil {
+			cursor := progress.Get(cursorKey)
+			if !r.o.Restart && string(progress.Get(modeKey)) == string(r.mode()) && cursor != nil {
+				r.cursor = string(cursor)
+				slog.Info("resuming reconcile", "backend", r.b.URI(), "after", r.cursor)
+				return nil
+			}
+			err = all.DeleteBucket(id)
+			if err != nil {
+				return err
+			}
+		}
 
-		bucket, err := tx.CreateBucket(bucketName)
+		progress, err := all.CreateBucket(id)
+		if err != nil {
+			return err
+		}
+		_, err = progress.CreateBucket(seenBucket)
 		if err != nil {
 			return err
 		}
-		return reconcile(bucket, b, "/")
+		return progress.Put(modeKey, r.mode())
 	})
 }
 
-func reconcile(bucket *bbolt.Bucket, b backend.Backend, path string) error {
-	files, err := b.List(path)
-	if err != nil {
+func (r *reconciler) finish() error {
+	return r.db.Update(r.b, func(tx *bbolt.Tx, id []byte) error {
+		all := tx.Bucket(progressBucket)
+		if all == nil {
+			return nil
+		}
+		err := all.DeleteBucket(id)
+		if errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
 		return err
+	})
+}
+
+func (r *reconciler) walk(dir string) error {
+	files, err := r.b.List(dir)
+	if dir == "/" && errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dir, err)
 	}
+	slices.SortFunc(files, func(a, b backend.File) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
 
 	for _, f := range files {
-		fullPath := filepath.Join(path, f.Name())
+		p := path.Join(dir, f.Name())
 		if f.IsDir() {
-			reconcile(bucket, b, fullPath)
-		} else {
-			versions := f.Versions()
+			if r.cursor != "" && comparePaths(p, r.cursor) < 0 && !strings.HasPrefix(r.cursor, p+"/") {
+				continue
+			}
+			err = r.walk(p)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-			err = database.SetUpdatedTime(bucket, fullPath, versions[len(versions)-1])
+		versions := f.Versions()
+		if len(versions) == 0 {
+			continue
+		}
+		if r.cursor != "" && comparePaths(p, r.cursor) <= 0 {
+			continue
+		}
+		r.pending = append(r.pending, &remoteFile{path: p, versions: versions})
+		if len(r.pending) >= r.batchSize {
+			err = r.flush()
 			if err != nil {
 				return err
 			}
@@ -44,3 +223,163 @@ func reconcile(bucket *bbolt.Bucket, b backend.Backend, path string) error {
 	}
 	return nil
 }
+
+// flush compares the pending files with the database and saves the progress.
+func (r *reconciler) flush() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	differences := []*Difference{}
+	err := r.db.Update(r.b, func(tx *bbolt.Tx, id []byte) error {
+		bucket := database.FilesBucket(tx, id)
+		progress := tx.Bucket(progressBucket).Bucket(id)
+		seen := progress.Bucket(seenBucket)
+
+		for _, f := range r.pending {
+			remote := f.versions[len(f.versions)-1]
+			local, err := localVersion(bucket, f.path)
+			if err != nil {
+				return err
+			}
+
+			var kind Kind
+			switch {
+			case local.IsZero():
+				kind = MissingLocally
+			case remote.Unix() > local.Unix():
+				kind = NewerRemotely
+			case remote.Unix() < local.Unix():
+				kind = MissingRemotely
+			}
+			if kind != "" {
+				differences = append(differences, &Difference{Kind: kind, Path: f.path, Local: local, Remote: remote})
+			}
+
+			if !r.o.DryRun {
+				if kind != "" {
+					err = database.SetVersions(bucket, f.path, f.versions)
+					if err != nil {
+						return err
+					}
+				}
+				err = database.IndexVersions(tx, id, f.path, f.versions)
+				if err != nil {
+					return err
+				}
+			}
+			err = seen.Put([]byte(f.path), []byte{1})
+			if err != nil {
+				return err
+			}
+		}
+		return progress.Put(cursorKey, []byte(r.pending[len(r.pending)-1].path))
+	})
+	if err != nil {
+		return err
+	}
+
+	r.cursor = r.pending[len(r.pending)-1].path
+	r.result.Checked += len(r.pending)
+	r.pending = r.pending[:0]
+	r.report(differences)
+	return nil
+}
+
+// removeMissing finds the files in the database that weren't found in the
+// backend and removes them so they are uploaded again.
+func (r *reconciler) removeMissing() error {
+	var after []byte
+	for {
+		missing := []*Difference{}
+		done := true
+		err := r.db.View(r.b, func(tx *bbolt.Tx, id []byte) error {
+			bucket := database.FilesBucket(tx, id)
+			if bucket == nil {
+				return nil
+			}
+			seen := tx.Bucket(progressBucket).Bucket(id).Bucket(seenBucket)
+
+			c := bucket.Cursor()
+			k, _ := c.First()
+			if after != nil {
+				k, _ = c.Seek(after)
+				if string(k) == string(after) {
+					k, _ = c.Next()
+				}
+			}
+			for ; k != nil; k, _ = c.Next() {
+				if len(missing) >= r.batchSize {
+					done = false
+					break
+				}
+				after = append(after[:0], k...)
+				if seen.Get(k) == nil {
+					f, err := database.GetFile(bucket, string(k))
+					if err != nil {
+						return err
+					}
+					missing = append(missing, &Difference{
+						Kind:  MissingRemotely,
+						Path:  string(k),
+						Local: f.Modified,
+					})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !r.o.DryRun && len(missing) > 0 {
+			err = r.db.Update(r.b, func(tx *bbolt.Tx, id []byte) error {
+				bucket := database.FilesBucket(tx, id)
+				for _, d := range missing {
+					err := bucket.Delete([]byte(d.Path))
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		r.report(missing)
+		if done {
+			return nil
+		}
+	}
+}
+
+func (r *reconciler) report(differences []*Difference) {
+	for _, d := range differences {
+		switch d.Kind {
+		case MissingLocally:
+			r.result.MissingLocally++
+		case MissingRemotely:
+			r.result.MissingRemotely++
+		case NewerRemotely:
+			r.result.NewerRemotely++
+		}
+		if r.o.Difference != nil {
+			r.o.Difference(d)
+		}
+	}
+}
+
+func localVersion(bucket *bbolt.Bucket, p string) (time.Time, error) {
+	f, err := database.GetFile(bucket, p)
+	if err != nil || f == nil {
+		return time.Time{}, err
+	}
+	return f.Modified, nil
+}
+
+// comparePaths orders paths the way they are walked, one directory level at a
+// time.
+func comparePaths(a, b string) int {
+	return slices.Compare(strings.Split(a, "/"), strings.Split(b, "/"))
+}
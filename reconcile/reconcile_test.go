@@ -0,0 +1,103 @@
+package reconcile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingBackend fails to list dir once.
+type failingBackend struct {
+	backend.Backend
+	dir    string
+	failed bool
+}
+
+func (b *failingBackend) List(p string) ([]backend.File, error) {
+	if p == b.dir && !b.failed {
+		b.failed = true
+		return nil, errors.New("connection reset")
+	}
+	return b.Backend.List(p)
+}
+
+func TestReconcile(t *testing.T) {
+	root := t.TempDir()
+	b := backend.NewFile(root)
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+	require.NoError(t, b.Write("/a/new.txt", t1, strings.NewReader("a")))
+	require.NoError(t, b.Write("/b/newer.txt", t2, strings.NewReader("b")))
+	require.NoError(t, b.Write("/c/same.txt", t1, strings.NewReader("c")))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "d", "empty"), 0700))
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.InitializeBackends([]backend.Backend{b}))
+	require.NoError(t, db.SetUpdatedTime(b, "/b/newer.txt", t1))
+	require.NoError(t, db.SetUpdatedTime(b, "/c/same.txt", t1))
+	require.NoError(t, db.SetUpdatedTime(b, "/gone.txt", t1))
+
+	differences := map[string]Kind{}
+	o := &Options{
+		DryRun:    true,
+		BatchSize: 1,
+		Difference: func(d *Difference) {
+			differences[d.Path] = d.Kind
+		},
+	}
+	result, err := Reconcile(db, b, o)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Kind{
+		"/a/new.txt":   MissingLocally,
+		"/b/newer.txt": NewerRemotely,
+		"/gone.txt":    MissingRemotely,
+	}, differences)
+	assert.Equal(t, &Result{Checked: 3, MissingLocally: 1, MissingRemotely: 1, NewerRemotely: 1}, result)
+
+	updated, err := db.GetUpdatedTime(b, "/b/newer.txt")
+	require.NoError(t, err)
+	assert.Equal(t, t1.Unix(), updated, "dry run changed the database")
+
+	// the second run is interrupted after the first batch and resumed
+	o.DryRun = false
+	failing := &failingBackend{Backend: b, dir: "/c"}
+	_, err = Reconcile(db, failing, o)
+	require.Error(t, err)
+
+	differences = map[string]Kind{}
+	result, err = Reconcile(db, failing, o)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Checked)
+	assert.Equal(t, map[string]Kind{"/gone.txt": MissingRemotely}, differences)
+
+	for p, want := range map[string]time.Time{
+		"/a/new.txt":   t1,
+		"/b/newer.txt": t2,
+		"/c/same.txt":  t1,
+		"/gone.txt":    {},
+	} {
+		updated, err := db.GetUpdatedTime(b, p)
+		require.NoError(t, err)
+		if want.IsZero() {
+			assert.Zero(t, updated, p)
+		} else {
+			assert.Equal(t, want.Unix(), updated, p)
+		}
+	}
+}
+
+func TestComparePaths(t *testing.T) {
+	assert.Negative(t, comparePaths("/a/b", "/a-c"))
+	assert.Negative(t, comparePaths("/a", "/a/b"))
+	assert.Zero(t, comparePaths("/a/b", "/a/b"))
+}
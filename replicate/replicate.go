@@ -0,0 +1,150 @@
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+)
+
+type Options struct {
+	// Since and Until limit the versions copied when they are set.
+	Since time.Time
+	Until time.Time
+	// DryRun lists the versions that would be copied to Output without
+	// writing them.
+	DryRun bool
+	Output io.Writer
+}
+
+type Result struct {
+	Copied  int
+	Skipped int
+	Failed  int
+	Bytes   int64
+}
+
+// Copy writes every version in from that isn't already in to. If db is not
+// nil the copied versions are recorded so the next backup doesn't upload them
+// to to again.
+func Copy(db *database.DB, from, to backend.Backend, o *Options) (*Result, error) {
+	c := &copier{
+		db:     db,
+		to:     to,
+		o:      o,
+		result: &Result{},
+		dirs:   map[string]map[string]backend.File{},
+	}
+
+	if db != nil && !o.DryRun {
+		err := db.InitializeBackends([]backend.Backend{to})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := backend.Walk(from, "/", c.copyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return c.result, err
+	}
+	if c.result.Failed > 0 {
+		return c.result, fmt.Errorf("failed to copy %d versions", c.result.Failed)
+	}
+	return c.result, nil
+}
+
+type copier struct {
+	db     *database.DB
+	to     backend.Backend
+	o      *Options
+	result *Result
+	// dirs caches the listings of the directories in to.
+	dirs map[string]map[string]backend.File
+}
+
+func (c *copier) copyFile(p string, f backend.File) error {
+	existing, err := c.existing(p)
+	if err != nil {
+		return err
+	}
+
+	var latest time.Time
+	for _, v := range f.Versions() {
+		if !c.o.Since.IsZero() && v.Before(c.o.Since) {
+			continue
+		}
+		if !c.o.Until.IsZero() && v.After(c.o.Until) {
+			continue
+		}
+		if existing != nil && slices.ContainsFunc(existing.Versions(), v.Equal) {
+			c.result.Skipped++
+			continue
+		}
+
+		if c.o.DryRun {
+			fmt.Fprintf(c.o.Output, "%s\t%s\n", v.Local().Format(time.DateTime), p)
+		} else {
+			err = c.copyVersion(p, f, v)
+			if err != nil {
+				c.result.Failed++
+				slog.Error("failed to copy version", "file", p, "version", v, "err", err)
+				continue
+			}
+		}
+		c.result.Copied++
+		c.result.Bytes += f.Size(v)
+		if v.After(latest) {
+			latest = v
+		}
+	}
+
+	if c.db == nil || c.o.DryRun || latest.IsZero() {
+		return nil
+	}
+	updated, err := c.db.GetUpdatedTime(c.to, p)
+	if err != nil {
+		return err
+	}
+	if updated >= latest.Unix() {
+		return nil
+	}
+	return c.db.SetUpdatedTime(c.to, p, latest)
+}
+
+func (c *copier) copyVersion(p string, f backend.File, v time.Time) error {
+	slog.Debug("copy version", "file", p, "version", v)
+	data, err := f.Data(v)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	return c.to.Write(p, v, data)
+}
+
+// existing returns the file at p in to or nil if it doesn't exist.
+func (c *copier) existing(p string) (backend.File, error) {
+	dir, name := path.Split(p)
+	files, ok := c.dirs[dir]
+	if !ok {
+		files = map[string]backend.File{}
+		list, err := c.to.List(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		for _, f := range list {
+			files[f.Name()] = f
+		}
+		c.dirs[dir] = files
+	}
+	return files[name], nil
+}
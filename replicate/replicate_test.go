@@ -0,0 +1,30 @@
+package replicate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	from := backend.NewFile(t.TempDir())
+	to := backend.NewFile(t.TempDir())
+
+	for _, unix := range []int64{100, 200, 300} {
+		require.NoError(t, from.Write("/a/b.txt", time.Unix(unix, 0), strings.NewReader("b")))
+	}
+	require.NoError(t, to.Write("/a/b.txt", time.Unix(100, 0), strings.NewReader("b")))
+
+	result, err := Copy(nil, from, to, &Options{Until: time.Unix(250, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Copied)
+	assert.Equal(t, 1, result.Skipped)
+
+	f, err := to.Read("/a/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{time.Unix(100, 0), time.Unix(200, 0)}, f.Versions())
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/metrics"
 	"github.com/gorilla/mux"
 )
 
@@ -80,7 +81,15 @@ func Start(s *Server) error {
 
 	fmt.Printf("listening at http://localhost:%d\n", s.Port)
 	r := mux.NewRouter()
+	r.Handle("/metrics", metrics.Handler())
 	r.PathPrefix("/files").Handler(http.StripPrefix("/files", http.HandlerFunc(files())))
 	http.ListenAndServe(fmt.Sprintf(":%d", s.Port), r)
 	return nil
 }
+
+// ListenMetrics serves only the /metrics endpoint on addr.
+func ListenMetrics(addr string) error {
+	r := mux.NewRouter()
+	r.Handle("/metrics", metrics.Handler())
+	return http.ListenAndServe(addr, r)
+}
@@ -0,0 +1,136 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// btrfs snapshots a directory that is the root of a btrfs subvolume. The
+// snapshot is created next to it.
+type btrfs struct{}
+
+func (*btrfs) Create(dir, name string) (*Snapshot, error) {
+	snapshot := path.Join(path.Dir(dir), "."+path.Base(dir)+"-"+name)
+	_, err := run("btrfs", "subvolume", "snapshot", "-r", dir, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Dir: snapshot,
+		remove: func() error {
+			_, err := run("btrfs", "subvolume", "delete", snapshot)
+			return err
+		},
+	}, nil
+}
+
+// zfs snapshots the dataset holding a directory and reads it from the
+// dataset's .zfs/snapshot directory.
+type zfs struct{}
+
+func (*zfs) Create(dir, name string) (*Snapshot, error) {
+	out, err := run("zfs", "list", "-H", "-o", "name,mountpoint", dir)
+	if err != nil {
+		return nil, err
+	}
+	dataset, mountpoint, ok := strings.Cut(out, "\t")
+	if !ok {
+		return nil, fmt.Errorf("unexpected zfs list output %q", out)
+	}
+	rel, err := filepath.Rel(mountpoint, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := dataset + "@" + name
+	_, err = run("zfs", "snapshot", snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Dir: path.Join(mountpoint, ".zfs", "snapshot", name, rel),
+		remove: func() error {
+			_, err := run("zfs", "destroy", snapshot)
+			return err
+		},
+	}, nil
+}
+
+// sameDevice returns true if the device paths a and b point at the same
+// device, e.g. /dev/mapper/vg-lv and /dev/vg/lv.
+func sameDevice(a, b string) bool {
+	realA, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+	realB, err := filepath.EvalSymlinks(b)
+	if err != nil {
+		return false
+	}
+	return realA == realB
+}
+
+// lvm snapshots a logical volume and mounts it read only in a temporary
+// directory.
+type lvm struct {
+	volume string
+	size   string
+}
+
+func (l *lvm) Create(dir, name string) (*Snapshot, error) {
+	vg, _, ok := strings.Cut(l.volume, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid lvm volume %q, expected vg/lv", l.volume)
+	}
+
+	// the snapshot only holds dir if it is on the configured volume
+	source, err := run("findmnt", "-n", "-o", "SOURCE", "--target", dir)
+	if err != nil {
+		return nil, err
+	}
+	if !sameDevice(source, "/dev/"+l.volume) {
+		return nil, fmt.Errorf("%s is on %s, not the lvm volume %s", dir, source, l.volume)
+	}
+	mountpoint, err := run("findmnt", "-n", "-o", "TARGET", "--target", dir)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(mountpoint, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = run("lvcreate", "--snapshot", "--name", name, "--size", l.size, l.volume)
+	if err != nil {
+		return nil, err
+	}
+	device := "/dev/" + vg + "/" + name
+	removeVolume := func() error {
+		_, err := run("lvremove", "-f", vg+"/"+name)
+		return err
+	}
+
+	target, err := os.MkdirTemp("", "backup-snapshot-")
+	if err != nil {
+		return nil, errors.Join(err, removeVolume())
+	}
+	_, err = run("mount", "-o", "ro", device, target)
+	if err != nil {
+		return nil, errors.Join(err, os.Remove(target), removeVolume())
+	}
+
+	return &Snapshot{
+		Dir: path.Join(target, rel),
+		remove: func() error {
+			_, err := run("umount", target)
+			if err != nil {
+				return err
+			}
+			return errors.Join(os.Remove(target), removeVolume())
+		},
+	}, nil
+}
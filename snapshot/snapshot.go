@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// Config selects the snapshot provider used to back up a consistent copy of
+// each directory.
+type Config struct {
+	// Type is btrfs, zfs or lvm. If it is empty no snapshots are taken.
+	Type string `mapstructure:"type"`
+	// Volume is the logical volume, as vg/lv, that holds the directories when
+	// Type is lvm.
+	Volume string `mapstructure:"volume"`
+	// Size is the space reserved for changes while an lvm snapshot exists.
+	Size string `mapstructure:"size"`
+}
+
+// A Provider creates read only snapshots of directories.
+type Provider interface {
+	// Create snapshots dir. name is unique to the run and can be used to name
+	// the snapshot.
+	Create(dir, name string) (*Snapshot, error)
+}
+
+type Snapshot struct {
+	// Dir is where the contents of the original directory can be read.
+	Dir    string
+	remove func() error
+}
+
+// Remove deletes the snapshot.
+func (s *Snapshot) Remove() error {
+	return s.remove()
+}
+
+// New returns the provider for c or nil if snapshots are disabled.
+func New(c *Config) (Provider, error) {
+	switch c.Type {
+	case "":
+		return nil, nil
+	case "btrfs":
+		return &btrfs{}, nil
+	case "zfs":
+		return &zfs{}, nil
+	case "lvm":
+		if c.Volume == "" {
+			return nil, fmt.Errorf("lvm snapshots need a volume")
+		}
+		size := c.Size
+		if size == "" {
+			size = "1G"
+		}
+		return &lvm{volume: c.Volume, size: size}, nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot type %q", c.Type)
+	}
+}
+
+// run runs a command and returns its trimmed stdout.
+func run(name string, args ...string) (string, error) {
+	slog.Debug("snapshot", "command", name, "args", args)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
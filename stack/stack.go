@@ -91,20 +91,29 @@ func (s *SyncDoneStack[T]) Finish(done bool) {
 	s.done = done
 }
 
+// popOrDone pops a value and reports whether the stack was finished in the
+// same lock, so a value pushed just before Finish can't be missed.
+func (s *SyncDoneStack[T]) popOrDone() (T, bool, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	v, ok := s.stack.Pop()
+	return v, ok, s.done
+}
+
 func (s *SyncDoneStack[T]) All() iter.Seq[T] {
 	return func(yield func(T) bool) {
-		var v T
-		var ok bool
 		for {
-			v, ok = s.Pop()
-			if !ok && s.done {
+			v, ok, done := s.popOrDone()
+			if !ok && done {
 				return
 			}
 			if !ok {
 				time.Sleep(time.Millisecond * 100)
 				continue
 			}
-			yield(v)
+			if !yield(v) {
+				return
+			}
 		}
 	}
 }
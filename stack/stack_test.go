@@ -42,3 +42,21 @@ func TestStack(t *testing.T) {
 	assert.Equal(t, 9, v)
 	assert.Equal(t, true, ok)
 }
+
+func TestSyncDoneStackAll(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		s := NewSyncDone[int]()
+		go func() {
+			for j := 0; j < 100; j++ {
+				s.Push(j)
+			}
+			s.Finish(true)
+		}()
+
+		n := 0
+		for range s.All() {
+			n++
+		}
+		assert.Equal(t, 100, n)
+	}
+}
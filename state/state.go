@@ -0,0 +1,140 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+)
+
+const dir = "state"
+
+// A State lists the latest version of every file in a backend. It is stored
+// in the backend after each backup so that a lost or outdated local database
+// can be restored without listing every file.
+type State struct {
+	Updated time.Time `json:"updated"`
+	// Files maps each path to the unix time of its latest version.
+	Files map[string]int64 `json:"files"`
+}
+
+func key(b backend.Backend) string {
+	if host := backend.NamespaceHost(b); host != "" {
+		return path.Join(dir, "hosts", host, "files.json.gz")
+	}
+	return path.Join(dir, "files.json.gz")
+}
+
+// Load returns the state stored in b or nil if it doesn't have one.
+func Load(b backend.Backend) (*State, error) {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return nil, nil
+	}
+	data, err := store.GetObject(key(b))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	s := &State{}
+	err = json.NewDecoder(zr).Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	return s, nil
+}
+
+// Save stores the files the database has recorded for b in b as of t.
+func Save(db *database.DB, b backend.Backend, t time.Time) error {
+	store, ok := backend.Objects(b)
+	if !ok {
+		return nil
+	}
+	times, _, err := db.UpdatedTimes(b.URI())
+	if err != nil {
+		return err
+	}
+
+	s := &State{
+		Updated: t,
+		Files:   make(map[string]int64, len(times)),
+	}
+	for p, t := range times {
+		s.Files[p] = t.Unix()
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	err = json.NewEncoder(zw).Encode(s)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	err = store.PutObject(key(b), buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return db.SetSynced(b.URI(), s.Updated)
+}
+
+// Sync makes sure the database knows which files are in b. If the state
+// stored in b is newer than the last one the database has seen the database
+// is restored from it. If the database has never seen b and there is no
+// stored state the files in b are listed instead.
+func Sync(db *database.DB, b backend.Backend) error {
+	remote, err := Load(b)
+	if err != nil {
+		return err
+	}
+	_, known, err := db.UpdatedTimes(b.URI())
+	if err != nil {
+		return err
+	}
+	synced, err := db.Synced(b.URI())
+	if err != nil {
+		return err
+	}
+
+	if remote != nil {
+		if known && !remote.Updated.After(synced) {
+			return nil
+		}
+		slog.Info("restoring local database from backend state", "backend", b.URI(), "updated", remote.Updated, "files", len(remote.Files))
+		files := make(map[string][]time.Time, len(remote.Files))
+		for p, t := range remote.Files {
+			files[p] = []time.Time{time.Unix(t, 0)}
+		}
+		return db.Restore(b, files, remote.Updated)
+	}
+	if known {
+		return nil
+	}
+
+	slog.Info("rebuilding local database from backend files", "backend", b.URI())
+	files := map[string][]time.Time{}
+	err = backend.Walk(b, "/", func(p string, f backend.File) error {
+		files[p] = f.Versions()
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to list backend files: %w", err)
+	}
+	return db.Restore(b, files, time.Time{})
+}
@@ -0,0 +1,78 @@
+package state
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abibby/backup/backend"
+	"github.com/abibby/backup/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openDB(t *testing.T) *database.DB {
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.bolt"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSync(t *testing.T) {
+	b := backend.NewFile(t.TempDir())
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+	require.NoError(t, b.Write("/a.txt", t1, strings.NewReader("a")))
+	require.NoError(t, b.Write("/a.txt", t2, strings.NewReader("a")))
+
+	// without a stored state the files are listed
+	db := openDB(t)
+	require.NoError(t, Sync(db, b))
+	updated, err := db.GetUpdatedTime(b, "/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, t2.Unix(), updated)
+
+	require.NoError(t, db.SetUpdatedTime(b, "/b.txt", t1))
+	require.NoError(t, Save(db, b, time.Unix(3000, 0)))
+
+	s, err := Load(b)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"/a.txt": 2000, "/b.txt": 1000}, s.Files)
+
+	// a new database is restored from the stored state
+	fresh := openDB(t)
+	require.NoError(t, Sync(fresh, b))
+	updated, err = fresh.GetUpdatedTime(b, "/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, t1.Unix(), updated)
+
+	// a database that is up to date is left alone
+	require.NoError(t, db.SetUpdatedTime(b, "/c.txt", t1))
+	require.NoError(t, Sync(db, b))
+	updated, err = db.GetUpdatedTime(b, "/c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, t1.Unix(), updated)
+}
+
+func TestSyncIgnoresOtherHosts(t *testing.T) {
+	root := backend.NewFile(t.TempDir())
+	alpha, err := backend.Namespace(root, "alpha")
+	require.NoError(t, err)
+	t1 := time.Unix(1000, 0)
+	require.NoError(t, alpha.Write("/a.txt", t1, strings.NewReader("a")))
+	require.NoError(t, root.Write("/b.txt", t1, strings.NewReader("b")))
+
+	db := openDB(t)
+	require.NoError(t, Sync(db, root))
+	times, _, err := db.UpdatedTimes(root.URI())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/b.txt"}, slices.Collect(maps.Keys(times)))
+
+	require.NoError(t, Sync(db, alpha))
+	times, _, err = db.UpdatedTimes(alpha.URI())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt"}, slices.Collect(maps.Keys(times)))
+}